@@ -0,0 +1,251 @@
+package arc
+
+import (
+	"container/list"
+	"time"
+
+	"mycache/policy"
+)
+
+/*
+ARC(Adaptive Replacement Cache，自适应替换缓存)缓存器，无锁。
+t1：只被访问过一次的记录（近期性）；t2：被访问过至少两次的记录（频率性）。
+b1、b2：分别是从t1、t2中淘汰的记录的“幽灵”，只保存key和大小，不保存值。
+p：t1的目标容量。命中b1说明t1太小，增大p；命中b2说明t2太小，减小p。
+一次性扫描的大量冷数据只会进入t1并在t1中被淘汰，不会挤掉t2中的热点数据。
+原始的ARC以记录条数计算容量，这里改为以字节计算，与其他淘汰策略保持一致。
+*/
+type Cache struct {
+	maxBytes  int64 // 允许使用的最大内存。0表示没有限制
+	p         int64 // t1的目标容量（字节）
+	t1, t2    *list.List
+	b1, b2    *list.List
+	sizes     [4]int64                             // t1、t2、b1、b2各自占用的字节数，下标为where
+	nexpire   int                                  // 设置了过期时间的记录数量
+	cache     map[string]*list.Element             // 键是字符串，值是所在链表（t1、t2、b1、b2之一）中对应节点的指针。
+	OnEvicted func(key string, value policy.Value) // 某条记录被移除时的回调函数，可以为 nil。
+}
+
+const (
+	inT1 = iota
+	inT2
+	inB1
+	inB2
+)
+
+type entry struct {
+	key    string
+	value  policy.Value // 幽灵记录的value为nil
+	size   int64        // len(key)+value.Len()，幽灵记录保留被淘汰时的大小
+	where  int          // 所在的链表
+	expire time.Time    // 过期时间，零值表示永不过期
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(maxBytes int64, onEvicted func(string, policy.Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+func (c *Cache) lists(where int) *list.List {
+	return [4]*list.List{c.t1, c.t2, c.b1, c.b2}[where]
+}
+
+func (c *Cache) Get(key string) (policy.Value, bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.where == inB1 || e.where == inB2 {
+		return nil, false
+	}
+	if e.expired(time.Now()) {
+		c.removeElement(ele, true)
+		return nil, false
+	}
+	// 再次访问，移入t2。
+	c.move(ele, inT2)
+	return e.value, true
+}
+
+func (c *Cache) Add(key string, val policy.Value) bool {
+	return c.AddWithExpire(key, val, time.Time{})
+}
+
+func (c *Cache) AddWithExpire(key string, val policy.Value, expire time.Time) bool {
+	size := int64(len(key)) + val.Len()
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return false
+	}
+	ele, ok := c.cache[key]
+	if !ok {
+		e := &entry{key: key, value: val, size: size, where: inT1}
+		c.setExpire(e, expire)
+		c.cache[key] = c.t1.PushFront(e)
+		c.sizes[inT1] += size
+		c.replace(false)
+		return true
+	}
+	e := ele.Value.(*entry)
+	ghost := e.where
+	switch ghost {
+	case inB1:
+		c.p = minInt64(c.maxBytes, c.p+maxInt64(size, size*c.sizes[inB2]/maxInt64(c.sizes[inB1], 1)))
+	case inB2:
+		c.p = maxInt64(0, c.p-maxInt64(size, size*c.sizes[inB1]/maxInt64(c.sizes[inB2], 1)))
+	}
+	c.move(ele, inT2)
+	c.sizes[inT2] += size - e.size
+	e.value, e.size = val, size
+	c.setExpire(e, expire)
+	c.replace(ghost == inB2)
+	return true
+}
+
+// 容量不足时，根据p决定从t1还是t2淘汰，被淘汰的记录成为幽灵记录；同时限制幽灵记录的总大小。
+func (c *Cache) replace(hitB2 bool) {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.sizes[inT1]+c.sizes[inT2] > c.maxBytes {
+		t1 := c.sizes[inT1]
+		if t1 > 0 && (t1 > c.p || (hitB2 && t1 == c.p) || c.t2.Len() == 0) {
+			c.demote(c.t1.Back(), inB1)
+		} else {
+			c.demote(c.t2.Back(), inB2)
+		}
+	}
+	for c.sizes[inT1]+c.sizes[inB1] > c.maxBytes && c.b1.Len() > 0 {
+		c.removeElement(c.b1.Back(), false)
+	}
+	for c.sizes[inT1]+c.sizes[inT2]+c.sizes[inB1]+c.sizes[inB2] > 2*c.maxBytes && c.b2.Len() > 0 {
+		c.removeElement(c.b2.Back(), false)
+	}
+}
+
+// 淘汰一条常驻记录，保留其key作为幽灵记录。
+func (c *Cache) demote(ele *list.Element, ghost int) {
+	e := ele.Value.(*entry)
+	value := e.value
+	c.move(ele, ghost)
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	e.value, e.expire = nil, time.Time{}
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, value)
+	}
+}
+
+// 将记录移动到where链表的front。
+func (c *Cache) move(ele *list.Element, where int) {
+	e := ele.Value.(*entry)
+	c.lists(e.where).Remove(ele)
+	c.sizes[e.where] -= e.size
+	e.where = where
+	c.cache[e.key] = c.lists(where).PushFront(e)
+	c.sizes[where] += e.size
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		c.removeElement(ele, e.where == inT1 || e.where == inT2)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	if c.nexpire == 0 {
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for _, l := range []*list.List{c.t1, c.t2} {
+		for ele := l.Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele, true)
+				removed++
+			}
+			ele = prev
+		}
+	}
+	return removed
+}
+
+func (c *Cache) Len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Keys 返回t1和t2中全部未过期记录的key，不包括幽灵记录。
+func (c *Cache) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, c.Len())
+	for _, l := range []*list.List{c.t1, c.t2} {
+		for ele := l.Front(); ele != nil; ele = ele.Next() {
+			if e := ele.Value.(*entry); !e.expired(now) {
+				keys = append(keys, e.key)
+			}
+		}
+	}
+	return keys
+}
+
+func (c *Cache) GetCurrentUsedBytes() int64 {
+	return c.sizes[inT1] + c.sizes[inT2]
+}
+
+func (c *Cache) GetMaxUsedBytes() int64 {
+	return c.maxBytes
+}
+
+func (c *Cache) setExpire(e *entry, expire time.Time) {
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if !expire.IsZero() {
+		c.nexpire++
+	}
+	e.expire = expire
+}
+
+// resident为true表示移除的是常驻记录，需要回调OnEvicted。
+func (c *Cache) removeElement(ele *list.Element, resident bool) {
+	e := ele.Value.(*entry)
+	c.lists(e.where).Remove(ele)
+	c.sizes[e.where] -= e.size
+	delete(c.cache, e.key)
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if resident && c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var _ policy.Policy = (*Cache)(nil)
@@ -0,0 +1,44 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+)
+
+type String string
+
+func (d String) Len() int64 {
+	return int64(len(d))
+}
+
+func TestGet(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// 每条记录占 2+2=4 字节，容量可以容纳10条。
+	arc := New(int64(40), nil)
+	hot := []string{"h0", "h1", "h2", "h3", "h4"}
+	for _, k := range hot {
+		arc.Add(k, String("vv"))
+		arc.Get(k)
+	}
+	for i := 0; i < 100; i++ {
+		arc.Add(fmt.Sprintf("%02d", i), String("vv"))
+	}
+	for _, k := range hot {
+		if _, ok := arc.Get(k); !ok {
+			t.Fatalf("hot key %s should survive a scan", k)
+		}
+	}
+	if arc.GetCurrentUsedBytes() > arc.GetMaxUsedBytes() {
+		t.Fatalf("used %d bytes, more than max %d", arc.GetCurrentUsedBytes(), arc.GetMaxUsedBytes())
+	}
+}
@@ -0,0 +1,118 @@
+package mycache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 表示远程节点的熔断器处于断开状态，请求没有发出。
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常发送请求
+	breakerOpen                         // 连续失败次数达到阈值，直接拒绝请求
+	breakerHalfOpen                     // 冷却时间已过，允许试探请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+/*
+circuitBreaker 是一个远程节点的熔断器。
+连续失败threshold次后断开（open），此后cooldown时间内的请求直接失败，不再等待注定超时的连接；
+冷却时间过后进入半开（half-open）状态，每个cooldown周期只放行一个试探请求：试探成功则闭合，失败则重新断开。
+nil表示不使用熔断器，全部方法都可以在nil上调用。
+*/
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // 连续失败次数
+	openedAt time.Time // 最近一次断开的时间
+	probedAt time.Time // 半开状态下最近一次放行试探请求的时间
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow 返回是否可以发出请求。
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		// 试探请求可能被调用方取消而没有结果，因此每个冷却周期重新放行一个。
+		if now.Sub(b.probedAt) < b.cooldown {
+			return false
+		}
+	default:
+		return true
+	}
+	b.probedAt = now
+	return true
+}
+
+// success 记录一次成功的请求（包括节点正常返回的业务错误），熔断器闭合。
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure 记录一次失败的请求（网络错误、超时或网关错误）。
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// snapshot 返回当前状态和连续失败次数。断开且冷却时间已过时报告为半开。
+func (b *circuitBreaker) snapshot() (breakerState, int) {
+	if b == nil {
+		return breakerClosed, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return breakerHalfOpen, b.failures
+	}
+	return b.state, b.failures
+}
@@ -1,11 +1,16 @@
 package mycache
 
-import "bytes"
+import (
+	"bytes"
+	"time"
+)
 
 // data 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，
 // 例如字符串、图片等。
 type ByteView struct {
-	data []byte
+	data    []byte
+	version uint64    // 写入时的毫秒时间戳，与持久化记录的Timestamp相同，副本之间按“最后写入者胜出”合并
+	expire  time.Time // 过期时间，零值表示永不过期或未知；复制到其他副本时随值一起发送
 }
 
 func (v ByteView) Len() int64 {
@@ -24,6 +29,22 @@ func (v ByteView) String() string {
 	return string(v.data)
 }
 
+// 过期时间的毫秒时间戳，没有过期时间时为0，用于pb.SetRequest和pb.KVResponse的expire字段。
+func (v ByteView) expireMillis() int64 {
+	if v.expire.IsZero() {
+		return 0
+	}
+	return v.expire.UnixMilli()
+}
+
+// expireMillis的逆运算。
+func expireFromMillis(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
 func cloneBytes(data []byte) []byte {
 	c := make([]byte, len(data))
 	copy(c, data)
@@ -2,16 +2,63 @@ package mycache
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"mycache/arc"
+	"mycache/lfu"
 	"mycache/lru"
 	"mycache/persistence"
+	"mycache/policy"
+	"mycache/tinylfu"
 )
 
-// 带锁的缓存器
+// 缓存淘汰策略，通过Conf.EvictionPolicy为每个Group单独选择。
+type EvictionPolicy string
+
+const (
+	LRU     EvictionPolicy = "lru"     // 最近最少使用，默认策略
+	LFU     EvictionPolicy = "lfu"     // 最不经常使用
+	ARC     EvictionPolicy = "arc"     // 自适应替换缓存，兼顾近期性与频率
+	TinyLFU EvictionPolicy = "tinylfu" // W-TinyLFU，基于count-min sketch的准入策略，适合扫描较多的场景
+)
+
+// 按淘汰策略创建无锁的缓存器，kind为空时使用LRU。
+func newPolicy(kind EvictionPolicy, maxBytes int64, onEvicted func(string, policy.Value)) (policy.Policy, error) {
+	switch kind {
+	case LRU, "":
+		return lru.New(maxBytes, onEvicted), nil
+	case LFU:
+		return lfu.New(maxBytes, onEvicted), nil
+	case ARC:
+		return arc.New(maxBytes, onEvicted), nil
+	case TinyLFU:
+		return tinylfu.New(maxBytes, onEvicted), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy: %q", kind)
+}
+
+const (
+	defaultCacheShards = 16
+	minShardBytes      = 1 << 20 // 使用默认分片数时，每个分片至少分得的容量
+)
+
+// 缓存分片：一把锁保护一个无锁的缓存器。不同分片之间互不影响，可以并发访问。
+type cacheShard struct {
+	mu       sync.Mutex
+	data     policy.Policy // 具体的淘汰策略
+	removing bool          // 正在主动删除记录，此时的OnEvicted回调不计入淘汰数量
+	tier     *diskTier     // 磁盘层，为nil时被淘汰的记录不再读取
+}
+
+/*
+带锁的缓存器，由多个独立加锁的分片组成，key按哈希值分配到分片，每个分片的容量为cacheBytes/分片数。
+淘汰策略的Get也会修改内部结构（如移动链表节点），因此读写都需要加分片的互斥锁；
+分片后，并发访问不同key时的锁竞争大大减少。
+*/
 type cache struct {
-	mu                 sync.RWMutex
-	data               *lru.Cache
+	shards             []*cacheShard
 	cacheBytes         int64 // 最大容量
 	groupName          string
 	enablePersistence  bool                       // 是否开启持久化
@@ -20,81 +67,353 @@ type cache struct {
 	fullPersistentFile string                     // 初始化时加载的全量持久化文件，例如"./persistence/{groupName}/full.bin"
 	incrPersistentFile string                     // 初始化时加载的增量持久化文件
 	writeSequence      *persistence.WriteSequence // 持久化工具
+	stop               chan struct{}              // Group.Close时关闭，停止后台清理
+	tier               *diskTier                  // 磁盘层，见tier.go，未启用时为nil
+	ttl                time.Duration              // Group的默认有效期，用于计算只保存在持久化文件中的记录的过期时间
+}
+
+/*
+新建缓存器。shards小于等于0时使用默认分片数defaultCacheShards，
+但容量较小时会减少分片数，使每个分片至少有minShardBytes的容量，避免较大的值无法放入分片。
+指定的分片数大于cacheBytes时减少到cacheBytes，使每个分片至少有1字节的容量（容量0表示没有限制）。
+cacheBytes不能被分片数整除时，余下的字节分给前面的分片。
+evictions不为nil时，记录因容量不足或过期被淘汰的数量。
+*/
+func newCache(kind EvictionPolicy, cacheBytes int64, shards int, evictions *AtomicInt) (cache, error) {
+	if shards <= 0 {
+		shards = defaultCacheShards
+		if cacheBytes > 0 && cacheBytes/int64(shards) < minShardBytes {
+			shards = int(cacheBytes / minShardBytes)
+		}
+		if shards < 1 {
+			shards = 1
+		}
+	}
+	if cacheBytes > 0 && int64(shards) > cacheBytes {
+		shards = int(cacheBytes)
+	}
+	c := cache{cacheBytes: cacheBytes, shards: make([]*cacheShard, shards)}
+	for i := range c.shards {
+		s := &cacheShard{}
+		onEvicted := func(key string, value policy.Value) {
+			if s.removing {
+				return
+			}
+			if evictions != nil {
+				evictions.Add(1)
+			}
+			if s.tier != nil { // 被淘汰的记录仍在持久化文件中，交给磁盘层管理；已过期的记录由磁盘层从持久化文件中删除
+				s.tier.add(key, value.(ByteView).expire)
+			}
+		}
+		shardBytes := cacheBytes / int64(shards)
+		if int64(i) < cacheBytes%int64(shards) {
+			shardBytes++
+		}
+		data, err := newPolicy(kind, shardBytes, onEvicted)
+		if err != nil {
+			return cache{}, err
+		}
+		s.data = data
+		c.shards[i] = s
+	}
+	return c, nil
+}
+
+// 按key的FNV-1a哈希值选择分片。
+func (c *cache) shard(key string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
 }
 
 type CacheInfo struct {
 	CurrentCacheBytes int64 // 最大容量
 	MaxCacheBytes     int64 // 当前容量
 	KeysNum           int64 // 键值对数量
+	DiskKeysNum       int64 // 只保存在磁盘层中的键值对数量，未启用磁盘层时为0
+	DiskLiveBytes     int64 // 持久化数据中有效记录占用的字节数
+	DiskDeadBytes     int64 // 持久化数据中被覆盖或删除、等待压缩回收的字节数
 }
 
-func (c *cache) init() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+/*
+从持久化文件中恢复缓存。持久化文件不记录过期时间，恢复的记录以写入时的版本加上ttl作为过期时间，
+已过期的记录不再放入内存。
+*/
+func (c *cache) init(ttl time.Duration) error {
 	keys := c.writeSequence.GetAllIndexKeys()
 	for _, key := range keys {
-		val, err := c.writeSequence.Get([]byte(key))
+		entry, err := c.writeSequence.GetEntry([]byte(key))
 		if err == nil {
-			value := ByteView{data: cloneBytes(val)}
-			c.data.Add(key, value)
+			expire := expireFromVersion(entry.Timestamp, ttl)
+			if !expire.IsZero() && time.Now().After(expire) {
+				continue
+			}
+			value := ByteView{data: cloneBytes(entry.Value), version: entry.Timestamp, expire: expire}
+			s := c.shard(key)
+			s.mu.Lock()
+			s.data.AddWithExpire(key, value, expire)
+			s.mu.Unlock()
 		}
 	}
 	return nil
 }
 
-func (c *cache) add(key string, val ByteView) error {
+// ttl小于等于0时返回零值，表示永不过期。
+func expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// 以version（写入时的毫秒时间戳）为写入时间、有效期为ttl的过期时间，用于持久化文件中没有记录过期时间的记录。ttl小于等于0时返回零值。
+func expireFromVersion(version uint64, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(version)).Add(ttl)
+}
+
+// 当前的毫秒时间戳，作为新写入的值的版本。
+func newVersion() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+// 写入key。val没有版本时以当前时间为版本。
+func (c *cache) add(key string, val ByteView, ttl time.Duration) error {
 	if len(key) == 0 {
 		return nil
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// 持久化和写入内存都在分片锁内完成，保证同一个key的写入顺序一致。
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.addLocked(s, key, val, ttl)
+}
+
+/*
+addIfNewer 只在本地没有key，或本地副本的版本不比val新时写入，返回是否写入。
+用于保存从其他副本复制来的值：较旧的写入晚到时被忽略，各副本最终保存版本最新的值。
+*/
+func (c *cache) addIfNewer(key string, val ByteView, ttl time.Duration) (bool, error) {
+	if len(key) == 0 {
+		return false, nil
+	}
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := c.getLocked(s, key); ok && cur.version > val.version {
+		return false, nil
+	}
+	return true, c.addLocked(s, key, val, ttl)
+}
 
+// 调用者需持有s.mu。val带有过期时间（例如从其他副本复制来的值）时使用它，否则以ttl为有效期。
+func (c *cache) addLocked(s *cacheShard, key string, val ByteView, ttl time.Duration) error {
+	if val.version == 0 {
+		val.version = newVersion()
+	}
+	if val.expire.IsZero() {
+		val.expire = expireAt(ttl)
+	}
+	if c.tier != nil { // 必须在写入持久化文件之前，否则磁盘层可能删除刚写入的记录
+		c.tier.remove(key)
+	}
 	if c.enablePersistence && c.writeSequence != nil {
-		err := c.writeSequence.Put([]byte(key), val.ByteSlice())
+		err := c.writeSequence.PutWithTimestamp([]byte(key), val.ByteSlice(), val.version)
 		if err != nil {
 			return err
 		}
 	}
-	c.data.Add(key, val)
+	if !s.data.AddWithExpire(key, val, val.expire) {
+		// 放不进内存时，内存中可能还保存着旧值，必须移除，否则会读到旧值；新值只保存在磁盘上。
+		s.removing = true
+		s.data.Remove(key)
+		s.removing = false
+		if c.tier != nil {
+			c.tier.add(key, val.expire)
+		}
+	}
 	return nil
 }
 
+/*
+get 可能移动链表节点或移除过期记录，因此需要加分片的互斥锁。
+内存中未命中且启用了磁盘层时，从持久化文件读取被淘汰的记录并放回内存。
+*/
 func (c *cache) get(key string) (val ByteView, ok bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if ans, ok := c.data.Get(key); ok {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ans, ok := s.data.Get(key); ok {
 		return ans.(ByteView), ok
 	}
+	if c.tier != nil {
+		if v, ok := c.tier.get(key); ok {
+			if !s.data.AddWithExpire(key, v, v.expire) {
+				c.tier.add(key, v.expire)
+			}
+			return v, true
+		}
+	}
 	return
 }
 
+/*
+getVersioned 返回本地保存的key的副本：先查找内存，未命中且开启了持久化时再查找持久化文件。
+从持久化文件读取的值不会写回内存，版本为写入时记录的时间戳。持久化文件不记录过期时间：
+启用了磁盘层时使用磁盘层记下的过期时间，否则以版本加上默认有效期作为过期时间，已过期的记录视为不存在。
+*/
+func (c *cache) getVersioned(key string) (ByteView, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.getLocked(s, key)
+}
+
+// 调用者需持有s.mu。
+func (c *cache) getLocked(s *cacheShard, key string) (ByteView, bool) {
+	if v, ok := s.data.Get(key); ok {
+		return v.(ByteView), true
+	}
+	if !c.enablePersistence || c.writeSequence == nil {
+		return ByteView{}, false
+	}
+	var expire time.Time
+	if c.tier != nil {
+		var ok bool
+		if expire, ok = c.tier.expireOf(key); !ok {
+			return ByteView{}, false
+		}
+	}
+	entry, err := c.writeSequence.GetEntry([]byte(key))
+	if err != nil {
+		return ByteView{}, false
+	}
+	if c.tier == nil {
+		expire = expireFromVersion(entry.Timestamp, c.ttl)
+	}
+	if !expire.IsZero() && time.Now().After(expire) {
+		return ByteView{}, false
+	}
+	return ByteView{data: entry.Value, version: entry.Timestamp, expire: expire}, true
+}
+
+// 返回本节点保存的全部key：内存中未过期的key，以及开启持久化时持久化文件中的key（可能已被移出内存）。
+func (c *cache) keys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, key := range s.data.Keys() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+	if c.enablePersistence && c.writeSequence != nil {
+		for _, key := range c.writeSequence.GetAllIndexKeys() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// 移除全部已过期的记录，释放其占用的容量。
+func (c *cache) removeExpired() int {
+	removed := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		removed += s.data.RemoveExpired()
+		s.mu.Unlock()
+	}
+	if c.tier != nil {
+		removed += c.tier.removeExpired()
+	}
+	return removed
+}
+
+// 后台定期清理过期记录，直到stop被关闭。
+func (c *cache) sweep(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
 func (c *cache) GetInfo() CacheInfo {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	ans := CacheInfo{
-		CurrentCacheBytes: c.data.GetCurrentUsedBytes(),
-		MaxCacheBytes:     int64(c.cacheBytes),
-		KeysNum:           int64(c.data.Len()),
+	ans := CacheInfo{MaxCacheBytes: int64(c.cacheBytes)}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		ans.CurrentCacheBytes += s.data.GetCurrentUsedBytes()
+		ans.KeysNum += int64(s.data.Len())
+		s.mu.Unlock()
+	}
+	if c.writeSequence != nil {
+		usage := c.writeSequence.DiskUsage()
+		ans.DiskLiveBytes, ans.DiskDeadBytes = usage.LiveBytes, usage.DeadBytes
+	}
+	if c.tier != nil {
+		ans.DiskKeysNum = int64(c.tier.len())
 	}
 	return ans
 }
 
 func (c *cache) delete(key string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.deleteLocked(s, key)
+}
+
+// 只在本地的key的版本不比version新时删除，用于迁移完成后删除旧值，不会误删迁移期间写入的新值。
+func (c *cache) deleteIfNotNewer(key string, version uint64) error {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := c.getLocked(s, key); ok && cur.version > version {
+		return nil
+	}
+	return c.deleteLocked(s, key)
+}
+
+// 调用者需持有s.mu。
+func (c *cache) deleteLocked(s *cacheShard, key string) error {
+	if c.tier != nil {
+		c.tier.remove(key)
+	}
 	if c.enablePersistence {
 		err := c.writeSequence.Delete([]byte(key))
 		if err != nil {
 			return err
 		}
 	}
-	c.data.Remove(key)
+	s.removing = true
+	s.data.Remove(key)
+	s.removing = false
 	return nil
 }
 
+// Merge和Backup期间由WriteSequence自身的锁阻塞并发写入。
 func (c *cache) backup() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.enablePersistence {
 		err := c.writeSequence.Merge()
 		if err != nil {
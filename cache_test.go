@@ -0,0 +1,156 @@
+package mycache
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 并发读写同一个缓存器，需要使用 go test -race 运行才能发现数据竞争。
+func TestCacheConcurrentAccess(t *testing.T) {
+	for _, kind := range []EvictionPolicy{LRU, LFU, ARC, TinyLFU} {
+		t.Run(string(kind), func(t *testing.T) {
+			c, err := newCache(kind, 4<<10, 8, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < 16; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 2000; i++ {
+						key := strconv.Itoa((g*31 + i) % 300)
+						switch i % 10 {
+						case 0:
+							c.add(key, ByteView{data: []byte(key)}, time.Millisecond)
+						case 1:
+							c.delete(key)
+						case 2:
+							c.removeExpired()
+						case 3:
+							c.GetInfo()
+						default:
+							if v, ok := c.get(key); ok && v.String() != key {
+								t.Errorf("get %s = %s", key, v.String())
+								return
+							}
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			if info := c.GetInfo(); info.CurrentCacheBytes > info.MaxCacheBytes {
+				t.Fatalf("used %d bytes, more than max %d", info.CurrentCacheBytes, info.MaxCacheBytes)
+			}
+		})
+	}
+}
+
+func TestCacheShards(t *testing.T) {
+	c, _ := newCache(LRU, 2<<10, 0, nil)
+	if len(c.shards) != 1 {
+		t.Fatalf("small cache should not be sharded, got %d shards", len(c.shards))
+	}
+	c, _ = newCache(LRU, 64<<20, 0, nil)
+	if len(c.shards) != defaultCacheShards {
+		t.Fatalf("got %d shards, want %d", len(c.shards), defaultCacheShards)
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		c.add(key, ByteView{data: []byte(key)}, 0)
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if v, ok := c.get(key); !ok || v.String() != key {
+			t.Fatalf("cache hit %s failed", key)
+		}
+	}
+	if info := c.GetInfo(); info.KeysNum != 1000 {
+		t.Fatalf("got %d keys, want 1000", info.KeysNum)
+	}
+	// 指定的分片数大于容量时，每个分片仍然有容量上限，余下的字节分给前面的分片。
+	c, _ = newCache(LRU, 10, 16, nil)
+	var total int64
+	for _, s := range c.shards {
+		if s.data.GetMaxUsedBytes() <= 0 {
+			t.Fatalf("shard capacity %d, want a limit", s.data.GetMaxUsedBytes())
+		}
+		total += s.data.GetMaxUsedBytes()
+	}
+	if len(c.shards) != 10 || total != 10 {
+		t.Fatalf("got %d shards with %d bytes, want 10 shards with 10 bytes", len(c.shards), total)
+	}
+	c, _ = newCache(LRU, 100, 8, nil)
+	total = 0
+	for _, s := range c.shards {
+		total += s.data.GetMaxUsedBytes()
+	}
+	if total != 100 {
+		t.Fatalf("shards hold %d bytes, want all 100", total)
+	}
+}
+
+// 新值放不进分片时，内存中的旧值也要移除，不能继续被读到。
+func TestCacheAddTooLarge(t *testing.T) {
+	c, _ := newCache(LRU, 64, 1, nil)
+	c.add("k", ByteView{data: []byte("small")}, 0)
+	c.add("k", ByteView{data: make([]byte, 500)}, 0)
+	if v, ok := c.get("k"); ok {
+		t.Fatalf("get k = %q after an oversized overwrite, want a miss", v.String())
+	}
+}
+
+func benchKeys(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	return names
+}
+
+// 使用 go test -bench CacheGet -cpu 1,2,4,8 观察吞吐量随GOMAXPROCS的变化。
+func BenchmarkCacheGet(b *testing.B) {
+	const keys = 1 << 12
+	for _, shards := range []int{1, defaultCacheShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c, _ := newCache(LRU, 0, shards, nil)
+			names := benchKeys(keys)
+			for _, key := range names {
+				c.add(key, ByteView{data: []byte(key)}, 0)
+			}
+			var seed int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&seed, 7919))
+				for pb.Next() {
+					c.get(names[i&(keys-1)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCacheAdd(b *testing.B) {
+	const keys = 1 << 12
+	for _, shards := range []int{1, defaultCacheShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c, _ := newCache(LRU, 1<<16, shards, nil)
+			names := benchKeys(keys)
+			value := ByteView{data: []byte("value")}
+			var seed int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&seed, 7919))
+				for pb.Next() {
+					c.add(names[i&(keys-1)], value, 0)
+					i++
+				}
+			})
+		})
+	}
+}
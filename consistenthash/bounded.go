@@ -0,0 +1,103 @@
+package consistenthash
+
+import "math"
+
+// 默认的负载上限系数：节点的负载最多为平均值的1.25倍。
+const DefaultEpsilon = 0.25
+
+/*
+Bounded 实现有界负载的一致性哈希（Consistent Hashing with Bounded Loads，Mirrokni等）。
+每个节点的容量为 ceil((1+epsilon) * (总负载+1) * 节点权重 / 总权重)，
+Get从key在哈希环上的位置开始顺时针查找，跳过负载已达到容量的节点，返回第一个未满的节点。
+因此热点区间的请求会溢出到后继节点，任何节点的负载都不会超过平均值的(1+epsilon)倍；
+全部节点负载为0时，与Map的结果相同。
+节点的负载（例如正在处理的请求数）由调用者通过SetLoad报告。
+*/
+type Bounded struct {
+	*Map
+	epsilon float64
+	loads   map[string]int64
+}
+
+// NewBounded 新建有界负载的一致性哈希，epsilon小于等于0时使用DefaultEpsilon。
+func NewBounded(replicas int, epsilon float64, fn Hash) *Bounded {
+	if epsilon <= 0 {
+		epsilon = DefaultEpsilon
+	}
+	return &Bounded{
+		Map:     New(replicas, fn),
+		epsilon: epsilon,
+		loads:   make(map[string]int64),
+	}
+}
+
+// SetLoad 报告节点当前的负载。
+func (b *Bounded) SetLoad(node string, load int64) {
+	if _, ok := b.weights[node]; ok {
+		b.loads[node] = load
+	}
+}
+
+// Load 返回最近一次报告的节点负载。
+func (b *Bounded) Load(node string) int64 {
+	return b.loads[node]
+}
+
+func (b *Bounded) Remove(nodes ...string) {
+	b.Map.Remove(nodes...)
+	for _, node := range nodes {
+		delete(b.loads, node)
+	}
+}
+
+// Get 返回key在哈希环上第一个负载未达到容量的节点。
+func (b *Bounded) Get(key string) string {
+	ans := ""
+	full := b.full()
+	b.successors(key, func(node string) bool {
+		if !full(node) {
+			ans = node
+			return false
+		}
+		return true
+	})
+	return ans
+}
+
+// GetN 按哈希环上的顺序返回至多n个节点，负载未达到容量的节点排在前面，第一个与Get相同。
+func (b *Bounded) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	var nodes, overloaded []string
+	full := b.full()
+	b.successors(key, func(node string) bool {
+		if full(node) {
+			overloaded = append(overloaded, node)
+		} else {
+			nodes = append(nodes, node)
+		}
+		return len(nodes) < n
+	})
+	nodes = append(nodes, overloaded...)
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// 返回判断节点负载是否已达到容量的函数。
+func (b *Bounded) full() func(node string) bool {
+	var total int64
+	for _, load := range b.loads {
+		total += load
+	}
+	totalWeight := 0
+	for _, w := range b.weights {
+		totalWeight += w
+	}
+	return func(node string) bool {
+		capacity := math.Ceil((1 + b.epsilon) * float64(total+1) * float64(b.weights[node]) / float64(totalWeight))
+		return float64(b.loads[node]) >= capacity
+	}
+}
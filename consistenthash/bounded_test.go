@@ -0,0 +1,58 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestBoundedSkipsOverloadedNode(t *testing.T) {
+	b := NewBounded(defaultTestReplicas, 0.25, nil)
+	b.Add(placementNodes(4)...)
+	ring := New(defaultTestReplicas, nil)
+	ring.Add(placementNodes(4)...)
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := b.Get(key), ring.Get(key); got != want {
+			t.Fatalf("without load, %s is assigned to %s, want %s as on the ring", key, got, want)
+		}
+	}
+
+	owner := b.Get("hot")
+	b.SetLoad(owner, 100)
+	if got := b.Get("hot"); got == owner || got == "" {
+		t.Fatalf("overloaded %s should be skipped, got %q", owner, got)
+	}
+	b.Remove(owner)
+	if b.Load(owner) != 0 {
+		t.Fatalf("load of removed node %s should be forgotten", owner)
+	}
+	b.AddWeighted(owner, 1)
+	if got := b.Get("hot"); got != owner {
+		t.Fatalf("re-added %s has no load and should own the key again, got %s", owner, got)
+	}
+}
+
+func TestBoundedLoadsNeverExceedBound(t *testing.T) {
+	const epsilon, n = 0.25, 2000
+	nodes := placementNodes(8)
+	b := NewBounded(defaultTestReplicas, epsilon, nil)
+	b.Add(nodes...)
+	b.AddWeighted(nodes[0], 2)
+
+	// 所有key集中在少数几个热点上，每次分配后负载加1，模拟不断增加的请求。
+	loads := make(map[string]int64)
+	for i := 0; i < n; i++ {
+		node := b.Get("hot" + strconv.Itoa(i%3))
+		loads[node]++
+		b.SetLoad(node, loads[node])
+	}
+	totalWeight := float64(len(nodes) + 1)
+	for _, node := range nodes {
+		bound := math.Ceil((1 + epsilon) * n * float64(b.Weight(node)) / totalWeight)
+		if float64(loads[node]) > bound {
+			t.Errorf("%s has load %d, bound is %v", node, loads[node], bound)
+		}
+	}
+}
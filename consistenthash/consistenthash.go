@@ -15,20 +15,25 @@ replicas：虚拟节点倍数。
 keys：哈希环。节点的名称对应的哈希值。一个真实节点对应多个虚拟节点。
 hashMap：虚拟节点与真实节点的映射表 hashMap，键是虚拟节点的哈希值，值是真实节点的名称。
 节点名称哈希值到节点名称的映射。由于虚拟节点的存在，可能有多个哈希值对应一个真实节点。
+不同真实节点的虚拟节点可能哈希冲突，因此hashMap的值是按名称排序的节点列表，由名称最小的节点负责该位置，
+与添加的顺序无关；该节点被移除后，由列表中的下一个节点接管。
 每个真实节点有一个唯一的名称作为标识符。
+weights：真实节点的权重。权重为w的节点有replicas*w个虚拟节点，负责的key的数量大致与权重成正比。
 */
 type Map struct {
 	hash     Hash
 	replicas int
 	keys     []int
-	hashMap  map[int]string
+	hashMap  map[int][]string
+	weights  map[string]int // 全部真实节点及其权重
 }
 
 func New(replicas int, fn Hash) *Map {
 	m := &Map{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
+		hashMap:  make(map[int][]string),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -36,14 +41,13 @@ func New(replicas int, fn Hash) *Map {
 	return m
 }
 
-// Add 函数允许传入0或多个真实节点的名称（或IP地址），并将这些节点追加到哈希环上m.keys。
+// Add 函数允许传入0或多个真实节点的名称（或IP地址），并将这些节点追加到哈希环上m.keys。权重均为1，已存在的节点会被忽略。
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+		if _, ok := m.weights[key]; ok {
+			continue
 		}
+		m.add(key, 1)
 	}
 	sort.Ints(m.keys)
 	/*
@@ -54,6 +58,104 @@ func (m *Map) Add(keys ...string) {
 	*/
 }
 
+/*
+AddWeighted 以权重weight添加一个真实节点，该节点有replicas*weight个虚拟节点，例如按内存容量（GB）设置权重。
+weight小于1时按1处理。节点已存在且权重不同时，按新的权重重新添加。
+*/
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if w, ok := m.weights[key]; ok {
+		if w == weight {
+			return
+		}
+		m.Remove(key)
+	}
+	m.add(key, weight)
+	sort.Ints(m.keys)
+}
+
+// Weight 返回节点的权重，节点不存在时返回0。
+func (m *Map) Weight(key string) int {
+	return m.weights[key]
+}
+
+// 添加节点的全部虚拟节点，调用者负责对m.keys排序。
+func (m *Map) add(key string, weight int) {
+	m.weights[key] = weight
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		owners := m.hashMap[hash]
+		if len(owners) == 0 {
+			m.keys = append(m.keys, hash)
+		}
+		m.hashMap[hash] = insertOwner(owners, key)
+	}
+}
+
+// Remove 从哈希环上移除真实节点及其全部虚拟节点，不存在的节点会被忽略。其他节点的虚拟节点位置不变。
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		weight, ok := m.weights[key]
+		if !ok {
+			continue
+		}
+		delete(m.weights, key)
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+			owners := removeOwner(m.hashMap[hash], key)
+			if len(owners) == 0 {
+				delete(m.hashMap, hash)
+				removed = true
+			} else {
+				m.hashMap[hash] = owners
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+	kept := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			kept = append(kept, hash)
+		}
+	}
+	m.keys = kept
+}
+
+// Nodes 按名称排序返回全部真实节点。
+func (m *Map) Nodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 将节点插入按名称排序的列表，已存在时不重复插入（同一节点的两个虚拟节点也可能冲突）。
+func insertOwner(owners []string, key string) []string {
+	i := sort.SearchStrings(owners, key)
+	if i < len(owners) && owners[i] == key {
+		return owners
+	}
+	owners = append(owners, "")
+	copy(owners[i+1:], owners[i:])
+	owners[i] = key
+	return owners
+}
+
+func removeOwner(owners []string, key string) []string {
+	i := sort.SearchStrings(owners, key)
+	if i < len(owners) && owners[i] == key {
+		return append(owners[:i], owners[i+1:]...)
+	}
+	return owners
+}
+
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 { // 如果一个节点都没有则返回""
 		return ""
@@ -69,7 +171,45 @@ func (m *Map) Get(key string) string {
 		return m.keys[i] >= hash
 	})
 
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	return m.hashMap[m.keys[idx%len(m.keys)]][0]
+}
+
+/*
+GetN 从key在哈希环上的位置开始顺时针查找，返回至多n个不同的真实节点，第一个是Get返回的节点。
+后面的节点可以作为key的副本：主节点不可用时，依次由它们负责。
+*/
+func (m *Map) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	var nodes []string
+	m.successors(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) < n
+	})
+	return nodes
+}
+
+// 从key在哈希环上的位置开始顺时针遍历，依次对每个不同的真实节点调用fn，fn返回false时停止。
+func (m *Map) successors(key string, fn func(node string) bool) {
+	if len(m.keys) == 0 {
+		return
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	seen := make(map[string]bool, len(m.weights))
+	for i := 0; i < len(m.keys) && len(seen) < len(m.weights); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]][0]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if !fn(node) {
+			return
+		}
+	}
 }
 
 /*
@@ -0,0 +1,105 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+const defaultTestReplicas = 50
+
+// 哈希值就是数字本身："2"+"4"即24。
+func digitHash(key []byte) uint32 {
+	i, _ := strconv.Atoi(string(key))
+	return uint32(i)
+}
+
+func TestHashing(t *testing.T) {
+	m := New(3, digitHash)
+	m.Add("6", "4", "2") // 2, 4, 6, 12, 14, 16, 22, 24, 26
+	cases := map[string]string{"2": "2", "11": "2", "23": "4", "27": "2"}
+	for k, v := range cases {
+		if m.Get(k) != v {
+			t.Errorf("asking for %s, should have yielded %s", k, v)
+		}
+	}
+	m.Add("8") // 8, 18, 28
+	cases["27"] = "8"
+	for k, v := range cases {
+		if m.Get(k) != v {
+			t.Errorf("asking for %s, should have yielded %s", k, v)
+		}
+	}
+}
+
+func TestAddIdempotentAndRemove(t *testing.T) {
+	m := New(3, digitHash)
+	m.Add("2", "4", "6")
+	m.Add("4")
+	if len(m.keys) != 9 {
+		t.Fatalf("ring has %d virtual nodes after adding 4 twice, want 9", len(m.keys))
+	}
+	m.Remove("4", "8")
+	if got := m.Nodes(); len(got) != 2 || got[0] != "2" || got[1] != "6" {
+		t.Fatalf("nodes after remove = %v", got)
+	}
+	if len(m.keys) != 6 {
+		t.Fatalf("ring has %d virtual nodes after remove, want 6", len(m.keys))
+	}
+	if m.Get("23") != "6" {
+		t.Errorf("23 should move to 6 after 4 is removed, got %s", m.Get("23"))
+	}
+	m.Remove("2", "6")
+	if m.Get("1") != "" {
+		t.Errorf("empty ring returned %q", m.Get("1"))
+	}
+}
+
+func TestCollision(t *testing.T) {
+	// 全部虚拟节点的哈希值都相同。
+	same := func([]byte) uint32 { return 7 }
+	a, b := New(2, same), New(2, same)
+	a.Add("x", "y")
+	b.Add("y", "x")
+	if a.Get("k") != "x" || b.Get("k") != "x" {
+		t.Fatalf("colliding nodes should resolve to the same owner regardless of order, got %s and %s", a.Get("k"), b.Get("k"))
+	}
+	a.Remove("x")
+	if a.Get("k") != "y" {
+		t.Fatalf("y should take over after x is removed, got %q", a.Get("k"))
+	}
+	a.Remove("y")
+	if len(a.keys) != 0 || a.Get("k") != "" {
+		t.Fatalf("ring should be empty, keys = %v", a.keys)
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	m := New(defaultTestReplicas, nil)
+	weights := map[string]int{"small:8001": 4, "large:8002": 32, "medium:8003": 8}
+	total := 0
+	for node, w := range weights {
+		m.AddWeighted(node, w)
+		total += w
+	}
+	const n = 100000
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[m.Get("key"+strconv.Itoa(i))]++
+	}
+	for node, w := range weights {
+		want := float64(n) * float64(w) / float64(total)
+		if got := float64(counts[node]); got < want*0.8 || got > want*1.2 {
+			t.Errorf("%s (weight %d) got %d keys, want about %.0f", node, w, counts[node], want)
+		}
+	}
+
+	// 修改权重后重新分配，移除后虚拟节点全部消失。
+	m.AddWeighted("small:8001", 1)
+	if m.Weight("small:8001") != 1 || len(m.keys) != defaultTestReplicas*(1+32+8) {
+		t.Fatalf("ring has %d virtual nodes after reweighting", len(m.keys))
+	}
+	m.Remove("small:8001")
+	if len(m.keys) != defaultTestReplicas*(32+8) {
+		t.Fatalf("ring has %d virtual nodes after remove", len(m.keys))
+	}
+}
@@ -0,0 +1,385 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+Placement 根据key选择负责它的节点。除基于哈希环的Map外，还实现了Rendezvous、Jump和Maglev三种算法。
+Placement不是并发安全的，由调用者（如HTTPPool）加锁。
+*/
+type Placement interface {
+	Add(nodes ...string)                 // 以权重1添加节点，已存在的节点会被忽略
+	AddWeighted(node string, weight int) // 以指定权重添加节点，或修改已有节点的权重
+	Remove(nodes ...string)              // 移除节点，不存在的节点会被忽略
+	Get(key string) string               // 返回key对应的节点，没有节点时返回""
+	GetN(key string, n int) []string     // 按优先顺序返回key对应的至多n个不同节点，第一个与Get相同
+	Weight(node string) int              // 返回节点的权重，节点不存在时返回0
+	Nodes() []string                     // 按名称排序返回全部节点
+}
+
+// Algorithm 是节点选择算法的名称。
+type Algorithm string
+
+const (
+	RingHash     Algorithm = "ring"       // 基于虚拟节点的一致性哈希环（Map），默认算法
+	Rendezvous   Algorithm = "rendezvous" // 最高随机权重（HRW）哈希
+	Jump         Algorithm = "jump"       // Jump一致性哈希
+	Maglev       Algorithm = "maglev"     // Maglev查找表
+	BoundedLoads Algorithm = "bounded"    // 有界负载的哈希环，负载由调用者通过SetLoad报告
+)
+
+// NewPlacement 按算法新建Placement，alg为空时使用RingHash。replicas只对RingHash和BoundedLoads有效。
+func NewPlacement(alg Algorithm, replicas int) (Placement, error) {
+	switch alg {
+	case RingHash, "":
+		return New(replicas, nil), nil
+	case Rendezvous:
+		return NewRendezvous(), nil
+	case Jump:
+		return NewJump(), nil
+	case Maglev:
+		return NewMaglev(0), nil
+	case BoundedLoads:
+		return NewBounded(replicas, DefaultEpsilon, nil), nil
+	}
+	return nil, fmt.Errorf("unknown placement algorithm: %q", alg)
+}
+
+var _ Placement = (*Map)(nil)
+
+// FNV-1a 64位哈希，再经过splitmix64的混合函数，使相近的字符串也能得到分布均匀的哈希值。
+func hashString(s string) uint64 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return mix64(h)
+}
+
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// 将node追加到nodes中，已存在时不重复追加。
+func appendDistinct(nodes []string, node string) []string {
+	for _, n := range nodes {
+		if n == node {
+			return nodes
+		}
+	}
+	return append(nodes, node)
+}
+
+// 节点及权重的集合，供各算法共用。
+type nodeSet map[string]int
+
+func (s nodeSet) Weight(node string) int {
+	return s[node]
+}
+
+func (s nodeSet) Nodes() []string {
+	nodes := make([]string, 0, len(s))
+	for node := range s {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+/*
+RendezvousHash 实现最高随机权重（Highest Random Weight）哈希：
+对每个节点计算 score = -weight / ln(hash(node, key))，选择得分最高的节点。
+增删节点时只有该节点负责的key会迁移，且分布均匀，不需要虚拟节点；但Get需要遍历全部节点，时间复杂度为O(节点数)。
+*/
+type RendezvousHash struct {
+	nodeSet
+	hashes map[string]uint64 // 节点名称的哈希值
+}
+
+func NewRendezvous() *RendezvousHash {
+	return &RendezvousHash{nodeSet: make(nodeSet), hashes: make(map[string]uint64)}
+}
+
+func (r *RendezvousHash) Add(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := r.nodeSet[node]; !ok {
+			r.AddWeighted(node, 1)
+		}
+	}
+}
+
+func (r *RendezvousHash) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.nodeSet[node] = weight
+	r.hashes[node] = hashString(node)
+}
+
+func (r *RendezvousHash) Remove(nodes ...string) {
+	for _, node := range nodes {
+		delete(r.nodeSet, node)
+		delete(r.hashes, node)
+	}
+}
+
+func (r *RendezvousHash) Get(key string) string {
+	kh := hashString(key)
+	best, bestScore := "", math.Inf(-1)
+	for node := range r.nodeSet {
+		score := r.score(kh, node)
+		if score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetN 按得分从高到低返回至多n个节点。
+func (r *RendezvousHash) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	kh := hashString(key)
+	nodes := r.Nodes()
+	scores := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		scores[node] = r.score(kh, node)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return scores[nodes[i]] > scores[nodes[j]] })
+	if n < len(nodes) {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+func (r *RendezvousHash) score(kh uint64, node string) float64 {
+	// 取哈希值的高53位，映射到(0, 1)。
+	u := (float64(mix64(kh^r.hashes[node])>>11) + 0.5) / (1 << 53)
+	return -float64(r.nodeSet[node]) / math.Log(u)
+}
+
+/*
+JumpHash 实现Jump一致性哈希（Lamping & Veach）：不需要额外内存，计算速度快，分布非常均匀。
+节点按名称排序后排列成桶，权重为w的节点占w个连续的桶，因此节点集合相同的实例总是得到相同的结果，与加入的顺序无关。
+Jump只能高效地在末尾增减桶：增删名称排在最后的节点时只有它负责的key会迁移；
+增删或修改中间节点的权重会使其后的桶整体移动，大量key会迁移。
+因此Jump只适合节点固定、或只追加名称排在最后的新节点（如node-01、node-02……）的集群，
+不适合由健康检查或gossip频繁增删节点的集群。
+*/
+type JumpHash struct {
+	nodeSet
+	buckets []string
+}
+
+func NewJump() *JumpHash {
+	return &JumpHash{nodeSet: make(nodeSet)}
+}
+
+func (j *JumpHash) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := j.nodeSet[node]; !ok {
+			j.nodeSet[node] = 1
+			changed = true
+		}
+	}
+	if changed {
+		j.rebuild()
+	}
+}
+
+func (j *JumpHash) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if j.nodeSet[node] == weight {
+		return
+	}
+	j.nodeSet[node] = weight
+	j.rebuild()
+}
+
+func (j *JumpHash) Remove(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := j.nodeSet[node]; ok {
+			delete(j.nodeSet, node)
+			changed = true
+		}
+	}
+	if changed {
+		j.rebuild()
+	}
+}
+
+// 按名称顺序重建桶。
+func (j *JumpHash) rebuild() {
+	j.buckets = j.buckets[:0]
+	for _, node := range j.Nodes() {
+		for i := 0; i < j.nodeSet[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+func (j *JumpHash) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jump(hashString(key), len(j.buckets))]
+}
+
+// GetN 从key对应的桶开始依次向后查找，返回至多n个不同的节点。
+func (j *JumpHash) GetN(key string, n int) []string {
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	var nodes []string
+	start := jump(hashString(key), len(j.buckets))
+	for i := 0; i < len(j.buckets) && len(nodes) < n; i++ {
+		nodes = appendDistinct(nodes, j.buckets[(start+i)%len(j.buckets)])
+	}
+	return nodes
+}
+
+// 论文 "A Fast, Minimal Memory, Consistent Hash Algorithm" 中的算法，返回[0, n)中的桶号。
+func jump(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Maglev查找表的默认大小，应为质数，且远大于节点数（论文建议至少为节点数的100倍）。
+const defaultMaglevTableSize = 65537
+
+/*
+MaglevHash 实现Google Maglev负载均衡器中的一致性哈希：
+每个节点按自己的排列（offset、skip）轮流填充大小为质数M的查找表，Get只需一次查表。
+各节点占用的表项数几乎完全相等（权重为w的节点每轮填充w个表项），增删节点时大部分key保持不变，
+但迁移量略多于哈希环和Rendezvous；增删节点需要重建查找表，时间复杂度为O(M)。
+*/
+type MaglevHash struct {
+	nodeSet
+	size  int
+	nodes []string // 按名称排序的节点，table中保存其下标
+	table []int
+}
+
+// NewMaglev 新建Maglev查找表，size小于等于0时使用defaultMaglevTableSize，size应为质数。
+func NewMaglev(size int) *MaglevHash {
+	if size <= 0 {
+		size = defaultMaglevTableSize
+	}
+	return &MaglevHash{nodeSet: make(nodeSet), size: size}
+}
+
+func (m *MaglevHash) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := m.nodeSet[node]; !ok {
+			m.nodeSet[node] = 1
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *MaglevHash) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if m.nodeSet[node] == weight {
+		return
+	}
+	m.nodeSet[node] = weight
+	m.populate()
+}
+
+func (m *MaglevHash) Remove(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := m.nodeSet[node]; ok {
+			delete(m.nodeSet, node)
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *MaglevHash) Get(key string) string {
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[hashString(key)%uint64(m.size)]]
+}
+
+// GetN 从key对应的表项开始依次向后查找，返回至多n个不同的节点。
+func (m *MaglevHash) GetN(key string, n int) []string {
+	if len(m.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	var nodes []string
+	start := int(hashString(key) % uint64(m.size))
+	for i := 0; i < m.size && len(nodes) < n && len(nodes) < len(m.nodes); i++ {
+		nodes = appendDistinct(nodes, m.nodes[m.table[(start+i)%m.size]])
+	}
+	return nodes
+}
+
+// 按论文中的算法重建查找表。
+func (m *MaglevHash) populate() {
+	m.nodes = m.Nodes()
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	M := uint64(m.size)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	next := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := hashString(node)
+		offsets[i] = h % M
+		skips[i] = mix64(h^0x9e3779b97f4a7c15)%(M-1) + 1
+	}
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := 0; ; {
+		for i, node := range m.nodes {
+			for w := 0; w < m.nodeSet[node]; w++ {
+				c := (offsets[i] + next[i]*skips[i]) % M
+				for table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % M
+				}
+				table[c] = i
+				next[i]++
+				filled++
+				if filled == m.size {
+					m.table = table
+					return
+				}
+			}
+		}
+	}
+}
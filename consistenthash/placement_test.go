@@ -0,0 +1,186 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+var algorithms = []Algorithm{RingHash, Rendezvous, Jump, Maglev}
+
+func placementNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = "10.0.0." + strconv.Itoa(i+1) + ":8001"
+	}
+	return nodes
+}
+
+func assign(p Placement, keys int) []string {
+	owners := make([]string, keys)
+	for i := range owners {
+		owners[i] = p.Get("key" + strconv.Itoa(i))
+	}
+	return owners
+}
+
+/*
+比较各算法的负载均衡程度（负载最高的节点与平均值之比）和移除一个节点时迁移的key的比例。
+理想情况下比例为1，迁移比例为1/节点数。使用 go test -v -run Distribution 查看结果。
+*/
+func TestPlacementDistributionAndMovement(t *testing.T) {
+	const nodes, keys = 10, 100000
+	maxRatio := map[Algorithm]float64{RingHash: 2.5, Rendezvous: 1.05, Jump: 1.05, Maglev: 1.05}
+	for _, alg := range algorithms {
+		p, err := NewPlacement(alg, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := placementNodes(nodes)
+		p.Add(names...)
+		before := assign(p, keys)
+		counts := make(map[string]int)
+		for _, owner := range before {
+			counts[owner]++
+		}
+		peak := 0
+		for _, c := range counts {
+			if c > peak {
+				peak = c
+			}
+		}
+		ratio := float64(peak) / (float64(keys) / nodes)
+
+		// Jump只能高效地移除名称排在最后的节点，其他算法移除中间的节点。
+		removed := names[nodes/2]
+		if alg == Jump {
+			removed = p.Nodes()[nodes-1]
+		}
+		p.Remove(removed)
+		moved := 0
+		for i, owner := range assign(p, keys) {
+			if owner == before[i] {
+				continue
+			}
+			moved++
+			// Maglev重建查找表时少量其他key也会迁移，只检查迁移的比例。
+			if before[i] != removed && alg != Maglev {
+				t.Errorf("%s: key%d moved from %s to %s although its owner was not removed", alg, i, before[i], owner)
+				break
+			}
+		}
+		movedRatio := float64(moved) / keys
+		t.Logf("%-10s max/avg load %.3f, moved %.3f of keys after removing 1 of %d nodes", alg, ratio, movedRatio, nodes)
+		if ratio > maxRatio[alg] {
+			t.Errorf("%s: max/avg load %.3f, want at most %.2f", alg, ratio, maxRatio[alg])
+		}
+		if movedRatio > 2.0/nodes {
+			t.Errorf("%s: moved %.3f of keys, want at most %.2f", alg, movedRatio, 2.0/nodes)
+		}
+	}
+}
+
+// 节点和权重相同的实例，无论节点以什么顺序加入，都为每个key选择相同的节点。
+func TestPlacementDeterministic(t *testing.T) {
+	names := placementNodes(10)
+	for _, alg := range algorithms {
+		a, _ := NewPlacement(alg, 50)
+		b, _ := NewPlacement(alg, 50)
+		for i, name := range names {
+			a.AddWeighted(name, i%3+1)
+		}
+		for i := len(names) - 1; i >= 0; i-- {
+			b.AddWeighted(names[i], i%3+1)
+		}
+		// 修改权重、移除后再加入，结果仍然相同。
+		b.AddWeighted(names[0], 5)
+		b.AddWeighted(names[0], 1)
+		b.Remove(names[4])
+		b.AddWeighted(names[4], 4%3+1)
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if a.Get(key) != b.Get(key) {
+				t.Fatalf("%s: %s maps to %s and %s on two instances with the same nodes", alg, key, a.Get(key), b.Get(key))
+			}
+		}
+	}
+}
+
+func TestPlacementWeights(t *testing.T) {
+	for _, alg := range algorithms {
+		p, _ := NewPlacement(alg, 50)
+		p.AddWeighted("small", 1)
+		p.AddWeighted("large", 3)
+		counts := make(map[string]int)
+		for _, owner := range assign(p, 40000) {
+			counts[owner]++
+		}
+		lo, hi := 2.4, 3.6
+		if alg == RingHash {
+			lo, hi = 2, 4.5 // crc32哈希环的分布本身就不均匀
+		}
+		if r := float64(counts["large"]) / float64(counts["small"]); r < lo || r > hi {
+			t.Errorf("%s: large/small = %.2f, want about 3", alg, r)
+		}
+		if p.Weight("large") != 3 || len(p.Nodes()) != 2 {
+			t.Errorf("%s: weight = %d, nodes = %v", alg, p.Weight("large"), p.Nodes())
+		}
+		p.Remove("small", "large")
+		if p.Get("key") != "" {
+			t.Errorf("%s: empty placement returned %q", alg, p.Get("key"))
+		}
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	for _, alg := range append(algorithms, BoundedLoads) {
+		p, _ := NewPlacement(alg, 50)
+		if nodes := p.GetN("key", 3); len(nodes) != 0 {
+			t.Errorf("%s: empty placement returned %v", alg, nodes)
+		}
+		p.Add(placementNodes(5)...)
+		for i := 0; i < 200; i++ {
+			key := "key" + strconv.Itoa(i)
+			nodes := p.GetN(key, 3)
+			if len(nodes) != 3 || nodes[0] != p.Get(key) {
+				t.Fatalf("%s: GetN(%s, 3) = %v, Get = %s", alg, key, nodes, p.Get(key))
+			}
+			seen := make(map[string]bool)
+			for _, node := range nodes {
+				if seen[node] {
+					t.Fatalf("%s: GetN(%s, 3) = %v has duplicates", alg, key, nodes)
+				}
+				seen[node] = true
+			}
+			// 主节点被移除后，原来的第一个副本成为主节点。
+			if alg != Jump && alg != Maglev {
+				p.Remove(nodes[0])
+				if got := p.Get(key); got != nodes[1] {
+					t.Fatalf("%s: after removing %s, %s moved to %s, want replica %s", alg, nodes[0], key, got, nodes[1])
+				}
+				p.Add(nodes[0])
+			}
+		}
+		if nodes := p.GetN("key", 10); len(nodes) != 5 {
+			t.Errorf("%s: GetN with n > nodes returned %v", alg, nodes)
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for _, alg := range algorithms {
+		for _, nodes := range []int{8, 64} {
+			b.Run(string(alg)+"/nodes="+strconv.Itoa(nodes), func(b *testing.B) {
+				p, _ := NewPlacement(alg, 50)
+				p.Add(placementNodes(nodes)...)
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = "key" + strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i&1023])
+				}
+			})
+		}
+	}
+}
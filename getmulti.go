@@ -0,0 +1,291 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+/*
+BatchGetter 是可选的批量Getter。传给NewGroup的getter同时实现了该接口时，
+GetMulti 中由本节点负责的多个key会通过一次GetMulti调用获得，而不是逐个调用Get。
+返回的values和errs必须与keys一一对应。
+*/
+type BatchGetter interface {
+	GetMulti(ctx context.Context, keys []string) (values [][]byte, errs []error)
+}
+
+// BatchTTLGetter 是同时为每个key返回过期时间的批量Getter，ttls与keys一一对应，含义与TTLGetter返回的ttl相同。
+type BatchTTLGetter interface {
+	GetMultiWithTTL(ctx context.Context, keys []string) (values [][]byte, ttls []time.Duration, errs []error)
+}
+
+// GetMulti 一次获得多个key对应的值，返回的values和errs与keys一一对应。
+func (g *Group) GetMulti(keys []string) ([]ByteView, []error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+/*
+GetMultiContext 一次获得多个key对应的值，返回的values和errs与keys一一对应。
+步骤：（1）查找mainCache；（2）未命中的key按归属节点（见pickOwner）分组，每个远程节点只发出一次批量请求；
+（3）由本节点负责的key（以及远程批量请求失败的key）通过BatchGetter或BatchTTLGetter批量导入，见getMultiLocally。
+一致性级别不是ONE且启用了副本时，批量请求只返回主节点上的值，无法按一致性级别比较各副本的版本，
+因此这些key不参与分组，而是与Get相同，逐个通过load按一致性级别读取副本（见readReplicas）。
+*/
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) ([]ByteView, []error) {
+	return g.getMulti(ctx, keys, true)
+}
+
+// route为false时不选择节点，未命中的key全部从本地导入，用于响应其他节点的批量获取请求。
+func (g *Group) getMulti(ctx context.Context, keys []string, route bool) ([]ByteView, []error) {
+	values := make([]ByteView, len(keys))
+	errs := make([]error, len(keys))
+	pending := make(map[string][]int) // 未命中的key -> 在keys中的下标（同一个key可能出现多次）
+	var order []string                // 未命中的key，保持首次出现的顺序
+	for i, key := range keys {
+		if key == "" {
+			errs[i] = errors.New("key is required")
+			continue
+		}
+		g.stats.Gets.Add(1)
+		if v, ok := g.mainCache.get(key); ok {
+			g.stats.Hits.Add(1)
+			values[i] = v
+			continue
+		}
+		g.stats.Misses.Add(1)
+		if _, ok := pending[key]; !ok {
+			order = append(order, key)
+		}
+		pending[key] = append(pending[key], i)
+	}
+	var mu sync.Mutex // 保护values和errs
+	set := func(key string, v ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range pending[key] {
+			values[i], errs[i] = v, err
+		}
+	}
+
+	var local, quorum []string
+	byPeer := make(map[PeerGetter][]string)
+	for _, key := range order {
+		if route && g.consistency != ConsistencyOne {
+			if _, _, ok := g.replicas(key); ok {
+				quorum = append(quorum, key)
+				continue
+			}
+		}
+		if route && g.peers != nil {
+			if peer, ok := g.pickOwner(key); ok {
+				byPeer[peer] = append(byPeer[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range quorum {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			v, err := g.load(ctx, key)
+			set(key, v, err)
+		}(key)
+	}
+	for peer, peerKeys := range byPeer {
+		wg.Add(1)
+		go func(peer PeerGetter, peerKeys []string) {
+			defer wg.Done()
+			failed := g.getMultiFromPeer(ctx, peer, peerKeys, set)
+			if _, ok := g.peers.(ReplicaPicker); ok {
+				// 主节点批量获取失败的key，逐个向副本节点获取。
+				var rest []string
+				for _, key := range failed {
+					if v, ok := g.getFromPeers(ctx, key, peer); ok {
+						set(key, v, nil)
+					} else {
+						rest = append(rest, key)
+					}
+				}
+				failed = rest
+			}
+			if len(failed) > 0 {
+				g.getMultiLocally(ctx, failed, set)
+			}
+		}(peer, peerKeys)
+	}
+	g.getMultiLocally(ctx, local, set)
+	wg.Wait()
+	return values, errs
+}
+
+/*
+响应其他节点的批量获取请求，结果与keys一一对应。
+发出请求的节点已经为这些key选择了本节点，因此未命中的key直接从本地导入，不再选择节点，见getForPeer。
+*/
+func (g *Group) serveMulti(ctx context.Context, keys []string) *pb.MultiResponse {
+	views, errs := g.getMulti(ctx, keys, false)
+	return multiResponse(keys, views, errs)
+}
+
+func multiResponse(keys []string, views []ByteView, errs []error) *pb.MultiResponse {
+	res := &pb.MultiResponse{Results: make([]*pb.KVResult, len(keys))}
+	for i, key := range keys {
+		res.Results[i] = &pb.KVResult{Key: key}
+		if errs[i] != nil {
+			res.Results[i].Error = errs[i].Error()
+		} else {
+			res.Results[i].Value = views[i].ByteSlice()
+		}
+	}
+	return res
+}
+
+/*
+向一个远程节点批量获取keys。peer未实现PeerBatchGetter时，逐个通过load获取。
+整个批量请求失败时返回全部keys，由调用方回退到本地导入；单个key的错误直接作为该key的结果。
+*/
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string, set func(string, ByteView, error)) (failed []string) {
+	bp, ok := peer.(PeerBatchGetter)
+	if !ok {
+		for _, key := range keys {
+			v, err := g.load(ctx, key)
+			set(key, v, err)
+		}
+		return nil
+	}
+	req := &pb.MultiRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	res := &pb.MultiResponse{}
+	err := bp.GetMulti(ctx, req, res)
+	if err == nil && len(res.Results) != len(keys) {
+		err = fmt.Errorf("batch get returned %d results for %d keys", len(res.Results), len(keys))
+	}
+	if err != nil {
+		g.stats.PeerErrors.Add(int64(len(keys)))
+		if ctx.Err() != nil {
+			for _, key := range keys {
+				set(key, ByteView{}, ctx.Err())
+			}
+			return nil
+		}
+		log.Println("[myCache] Failed to get multi from peer", err)
+		return keys
+	}
+	for i, r := range res.Results {
+		if r.GetError() != "" {
+			g.stats.PeerErrors.Add(1)
+			set(keys[i], ByteView{}, errors.New(r.GetError()))
+		} else {
+			g.stats.PeerLoads.Add(1)
+			set(keys[i], ByteView{data: r.GetValue()}, nil)
+		}
+	}
+	return nil
+}
+
+/*
+从本地导入多个key，导入成功的值写入缓存。每个key都经过single flight，与同时进行的Get和GetMulti共享导入结果。
+getter支持批量导入时（见batchGetter），不在导入中的key通过一次批量调用获得，否则逐个通过getLocally导入。
+*/
+func (g *Group) getMultiLocally(ctx context.Context, keys []string, set func(string, ByteView, error)) {
+	if len(keys) == 0 {
+		return
+	}
+	batch := g.batchGetter()
+	if batch == nil || len(keys) == 1 {
+		for _, key := range keys {
+			executed := false
+			v, err := g.loader.DoContext(ctx, key, func() (any, error) {
+				executed = true
+				return g.getLocally(ctx, key)
+			})
+			if !executed {
+				g.stats.DedupedLoads.Add(1)
+			}
+			if err != nil {
+				set(key, ByteView{}, err)
+			} else {
+				set(key, v.(ByteView), nil)
+			}
+		}
+		return
+	}
+	vals, errs, shared := g.loader.DoMultiContext(ctx, keys, func(keys []string) ([]any, []error) {
+		return g.getBatchLocally(ctx, keys, batch)
+	})
+	for i, key := range keys {
+		if shared[i] {
+			g.stats.DedupedLoads.Add(1)
+		}
+		if errs[i] != nil {
+			set(key, ByteView{}, errs[i])
+		} else {
+			set(key, vals[i].(ByteView), nil)
+		}
+	}
+}
+
+/*
+返回批量导入的函数，getter不支持批量导入时返回nil。
+getter实现了BatchTTLGetter时使用它；getter实现了TTLGetter或ContextTTLGetter但没有实现BatchTTLGetter时，
+BatchGetter无法返回每个key的过期时间，因此逐个导入，使过期时间与Get相同。
+*/
+func (g *Group) batchGetter() func(ctx context.Context, keys []string) ([][]byte, []time.Duration, []error) {
+	if bg, ok := g.getter.(BatchTTLGetter); ok {
+		return bg.GetMultiWithTTL
+	}
+	switch g.getter.(type) {
+	case TTLGetter, ContextTTLGetter:
+		return nil
+	}
+	if bg, ok := g.getter.(BatchGetter); ok {
+		return func(ctx context.Context, keys []string) ([][]byte, []time.Duration, []error) {
+			values, errs := bg.GetMulti(ctx, keys)
+			return values, nil, errs
+		}
+	}
+	return nil
+}
+
+// 通过一次批量调用导入keys，结果与keys一一对应。
+func (g *Group) getBatchLocally(ctx context.Context, keys []string, batch func(context.Context, []string) ([][]byte, []time.Duration, []error)) ([]any, []error) {
+	vals, errs := make([]any, len(keys)), make([]error, len(keys))
+	fail := func(err error) ([]any, []error) {
+		g.stats.LocalLoadErrors.Add(int64(len(keys)))
+		for i := range keys {
+			vals[i], errs[i] = ByteView{}, err
+		}
+		return vals, errs
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	bytes, ttls, batchErrs := batch(ctx, keys)
+	if len(bytes) != len(keys) || len(batchErrs) != len(keys) || (ttls != nil && len(ttls) != len(keys)) {
+		return fail(fmt.Errorf("BatchGetter returned %d values, %d ttls and %d errors for %d keys", len(bytes), len(ttls), len(batchErrs), len(keys)))
+	}
+	for i, key := range keys {
+		if batchErrs[i] != nil {
+			g.stats.LocalLoadErrors.Add(1)
+			vals[i], errs[i] = ByteView{}, batchErrs[i]
+			continue
+		}
+		var ttl time.Duration
+		if ttls != nil {
+			ttl = ttls[i]
+		}
+		vals[i] = g.storeLoaded(key, bytes[i], ttl)
+	}
+	return vals, errs
+}
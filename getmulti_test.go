@@ -0,0 +1,161 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+// 批量Getter：记录每次调用的keys，key以"missing"开头时返回错误。
+type batchGetter struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (b *batchGetter) Get(key string) ([]byte, error) {
+	vs, errs := b.GetMulti(context.Background(), []string{key})
+	return vs[0], errs[0]
+}
+
+func (b *batchGetter) GetMulti(ctx context.Context, keys []string) ([][]byte, []error) {
+	b.mu.Lock()
+	b.calls = append(b.calls, keys)
+	b.mu.Unlock()
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		if strings.HasPrefix(key, "missing") {
+			errs[i] = errors.New(key + " not exist")
+		} else {
+			values[i] = []byte("v-" + key)
+		}
+	}
+	return values, errs
+}
+
+// 按key的首字母选择节点的PeerPicker，"r"开头的key属于远程节点。
+type prefixPicker struct {
+	remote PeerGetter
+}
+
+func (p *prefixPicker) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "r") {
+		return p.remote, true
+	}
+	return nil, false
+}
+
+func (p *prefixPicker) GetAll() []PeerGetter {
+	return []PeerGetter{p.remote}
+}
+
+func TestGetMulti(t *testing.T) {
+	// 远程节点：没有注册PeerPicker的Group，通过HTTP批量接口访问。
+	remoteGetter := &batchGetter{}
+	remoteGroup := newTestGroup(t, Conf{Name: "multi-remote"}, 2<<10, remoteGetter)
+	_, server := newRemoteNode(t, "http://remote", "multi-local", remoteGroup)
+	remote := &httpGetter{baseURL: server.URL + defaultBasePath}
+
+	localGetter := &batchGetter{}
+	g := newTestGroup(t, Conf{Name: "multi-local"}, 2<<10, localGetter)
+	g.RegisterPeers(&prefixPicker{remote: remote})
+
+	keys := []string{"a", "r1", "b", "r2", "missing", "a", ""}
+	values, errs := g.GetMulti(keys)
+	want := []string{"v-a", "v-r1", "v-b", "v-r2", "", "v-a", ""}
+	for i := range keys {
+		if values[i].String() != want[i] {
+			t.Errorf("key %q = %q, want %q", keys[i], values[i].String(), want[i])
+		}
+	}
+	if errs[4] == nil || errs[6] == nil {
+		t.Errorf("missing and empty keys should fail, got %v", errs)
+	}
+	if len(localGetter.calls) != 1 || len(localGetter.calls[0]) != 3 {
+		t.Errorf("local keys should be loaded in one batch, got %v", localGetter.calls)
+	}
+	if len(remoteGetter.calls) != 1 || len(remoteGetter.calls[0]) != 2 {
+		t.Errorf("remote keys should be loaded in one batch, got %v", remoteGetter.calls)
+	}
+
+	// 本地的key已经写入缓存，不会再调用getter。
+	g.GetMulti([]string{"a", "b"})
+	if len(localGetter.calls) != 1 {
+		t.Errorf("cached keys should not be loaded again, got %v", localGetter.calls)
+	}
+}
+
+// GetMulti和Get同时导入同一个key时，只调用一次getter。
+func TestGetMultiDedup(t *testing.T) {
+	getter := &blockingBatchGetter{release: make(chan struct{}), started: make(chan struct{}, 10)}
+	g := newTestGroup(t, Conf{Name: "multi-dedup"}, 2<<10, getter)
+	multi := make(chan []ByteView, 1)
+	go func() {
+		values, _ := g.GetMulti([]string{"a", "b"})
+		multi <- values
+	}()
+	<-getter.started
+	single := make(chan ByteView, 1)
+	go func() {
+		v, _ := g.Get("a")
+		single <- v
+	}()
+	time.Sleep(20 * time.Millisecond) // 等待Get加入single flight
+	close(getter.release)
+	if values := <-multi; values[0].String() != "v-a" || values[1].String() != "v-b" {
+		t.Fatalf("GetMulti = %v", values)
+	}
+	if v := <-single; v.String() != "v-a" {
+		t.Fatalf("Get = %q", v.String())
+	}
+	if n := atomic.LoadInt32(&getter.loads); n != 2 {
+		t.Fatalf("getter loaded %d keys, want a and b once each", n)
+	}
+	if stats := g.GetStats(); stats.DedupedLoads.Get() != 1 {
+		t.Fatalf("deduped loads = %d, want 1", stats.DedupedLoads.Get())
+	}
+}
+
+// 批量Getter：每次调用都等待release，并按key计数。
+type blockingBatchGetter struct {
+	release chan struct{}
+	started chan struct{}
+	loads   int32
+}
+
+func (b *blockingBatchGetter) Get(key string) ([]byte, error) {
+	vs, errs := b.GetMulti(context.Background(), []string{key})
+	return vs[0], errs[0]
+}
+
+func (b *blockingBatchGetter) GetMulti(ctx context.Context, keys []string) ([][]byte, []error) {
+	b.started <- struct{}{}
+	<-b.release
+	atomic.AddInt32(&b.loads, int32(len(keys)))
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = []byte("v-" + key)
+	}
+	return values, make([]error, len(keys))
+}
+
+func TestHTTPGetMulti(t *testing.T) {
+	newTestGroup(t, Conf{Name: "multi-http"}, 2<<10, &batchGetter{})
+	server := httptest.NewServer(NewHTTPPool("http://remote"))
+	defer server.Close()
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	res := &pb.MultiResponse{}
+	if err := peer.GetMulti(context.Background(), &pb.MultiRequest{Group: "multi-http", Keys: []string{"x", "missing"}}, res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 2 || string(res.Results[0].Value) != "v-x" || res.Results[1].Error == "" {
+		t.Fatalf("unexpected batch response %v", res.Results)
+	}
+}
@@ -0,0 +1,52 @@
+package mycache
+
+import (
+	"context"
+	"net/http"
+
+	"mycache/gossip"
+)
+
+/*
+StartGossip 启动基于SWIM协议的成员管理，由gossip自动维护一致性哈希，不再需要在每个节点上调用Set传入完整的节点列表。
+新节点只需知道任意一个已在集群中的节点（seeds），加入后其他节点会陆续得知；
+探测失败的节点先被标记为可疑，SuspicionTimeout内没有反驳则被移出一致性哈希。
+conf.Self、conf.Path和conf.OnChange由HTTPPool设置，conf.Client为nil时使用HTTPPoolOptions.Client；
+conf.Weight是本节点在一致性哈希中的权重，会随gossip传播给其他节点。
+所有种子节点都无法访问时返回错误，但成员管理仍会启动，其他节点可以通过本节点加入。ctx被取消时停止。
+*/
+func (p *HTTPPool) StartGossip(ctx context.Context, conf gossip.Config, seeds ...string) (*gossip.Memberlist, error) {
+	conf.Self = p.self
+	conf.Path = internalBasePath + "gossip/"
+	conf.OnChange = p.SetWeighted
+	if conf.Client == nil {
+		conf.Client = p.opts.Client
+	}
+	if conf.Logf == nil {
+		conf.Logf = p.Log
+	}
+	m := gossip.New(conf)
+	p.mu.Lock()
+	p.gossip = m
+	p.mu.Unlock()
+	weight := conf.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	p.SetWeighted(map[string]int{p.self: weight})
+	err := m.Join(ctx, seeds...)
+	m.Start(ctx)
+	return m, err
+}
+
+// 处理其他节点发来的gossip消息，没有启动成员管理时返回404。
+func (p *HTTPPool) serveGossip(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	m := p.gossip
+	p.mu.Unlock()
+	if m == nil {
+		http.Error(w, "gossip is not started", http.StatusNotFound)
+		return
+	}
+	m.ServeHTTP(w, r)
+}
@@ -0,0 +1,609 @@
+/*
+gossip 实现基于SWIM协议（Scalable Weakly-consistent Infection-style Process Group Membership）的集群成员管理。
+每个节点每隔ProbeInterval轮流探测一个成员：直接ping超时后，请IndirectChecks个其他成员代为探测（ping-req），
+仍然失败则将目标标记为可疑（suspect）；可疑的节点在SuspicionTimeout内没有以更大的incarnation宣布自己存活（反驳），
+则被判定为死亡，死亡的记录保留DeadTimeout后删除。成员状态的变化附带在ping和ack中传播（piggyback），每条变化最多转发RetransmitMult*log10(n+1)次；
+另外每隔SyncInterval与一个随机成员交换完整的成员列表（push-pull），新节点也通过与种子节点同步加入集群。
+节点之间通过HTTP POST传递序列化的pb.GossipMessage。
+*/
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "mycache/mycachepb"
+)
+
+// State 是成员的状态。
+type State int32
+
+const (
+	Alive   State = iota // 正常
+	Suspect              // 探测失败，等待反驳
+	Dead                 // 可疑超时，已被移出集群
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// Member 是一个成员的状态。
+type Member struct {
+	Addr        string // 节点地址，如"http://10.0.0.2:8008"
+	State       State
+	Incarnation uint64 // 只由节点自己递增，用于反驳关于它的可疑和死亡消息
+	Weight      int    // 节点在一致性哈希中的权重
+}
+
+const (
+	defaultPath             = "/gossip/"
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 500 * time.Millisecond
+	defaultIndirectChecks   = 3
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultDeadTimeout      = time.Minute
+	defaultSyncInterval     = 30 * time.Second
+	defaultRetransmitMult   = 4
+	maxPiggyback            = 16      // 每条消息最多附带的状态变化数
+	maxMessageBytes         = 4 << 20 // 消息的最大字节数，足够容纳数万个成员
+)
+
+// Config 是Memberlist的配置。数值字段小于等于0时使用默认值。
+type Config struct {
+	Self             string        // 本节点的地址，其他节点向 Self+Path+操作名 发送消息
+	Path             string        // 消息的路径前缀，默认为defaultPath
+	Weight           int           // 本节点的权重，默认为1
+	ProbeInterval    time.Duration // 探测间隔，默认为defaultProbeInterval
+	ProbeTimeout     time.Duration // 直接探测的超时时间，间接探测为它的2倍，默认为defaultProbeTimeout
+	IndirectChecks   int           // 间接探测的成员数，默认为defaultIndirectChecks
+	SuspicionTimeout time.Duration // 可疑的成员经过多久被判定为死亡，默认为defaultSuspicionTimeout
+	DeadTimeout      time.Duration // 死亡的成员记录保留多久，此后被删除，默认为defaultDeadTimeout
+	SyncInterval     time.Duration // 与随机成员交换完整成员列表的间隔，默认为defaultSyncInterval
+	RetransmitMult   int           // 每条状态变化的转发次数系数，默认为defaultRetransmitMult
+	Client           *http.Client  // 发送消息使用的客户端，默认为http.DefaultClient
+
+	// OnChange 在存活（包括可疑）成员的集合或权重变化时被串行调用，参数为全部存活成员（包括本节点）的地址到权重的映射。
+	OnChange func(members map[string]int)
+	Logf     func(format string, v ...any) // 记录成员状态的变化，默认为log.Printf
+}
+
+type member struct {
+	Member
+	suspectedAt time.Time // 最近一次被标记为可疑的时间
+	deadAt      time.Time // 被判定为死亡的时间
+}
+
+// 等待附带在消息中传播的状态变化。
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// Memberlist 维护本节点看到的集群成员列表，可以并发使用。
+type Memberlist struct {
+	conf Config
+
+	mu         sync.Mutex
+	members    map[string]*member // 全部成员（包括本节点和死亡的成员），死亡的记录在DeadTimeout内用于忽略过时的消息
+	queue      []*broadcast
+	probeOrder []string // 本轮探测的顺序，每轮随机打乱
+	probeIndex int
+
+	notifyMu sync.Mutex
+	notified map[string]int // 最近一次传给OnChange的成员
+}
+
+// New 新建只包含本节点的成员列表。
+func New(conf Config) *Memberlist {
+	if conf.Path == "" {
+		conf.Path = defaultPath
+	}
+	if conf.Weight <= 0 {
+		conf.Weight = 1
+	}
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = defaultProbeInterval
+	}
+	if conf.ProbeTimeout <= 0 {
+		conf.ProbeTimeout = defaultProbeTimeout
+	}
+	if conf.IndirectChecks <= 0 {
+		conf.IndirectChecks = defaultIndirectChecks
+	}
+	if conf.SuspicionTimeout <= 0 {
+		conf.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if conf.DeadTimeout <= 0 {
+		conf.DeadTimeout = defaultDeadTimeout
+	}
+	if conf.SyncInterval <= 0 {
+		conf.SyncInterval = defaultSyncInterval
+	}
+	if conf.RetransmitMult <= 0 {
+		conf.RetransmitMult = defaultRetransmitMult
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	if conf.Logf == nil {
+		conf.Logf = log.Printf
+	}
+	m := &Memberlist{conf: conf, members: make(map[string]*member)}
+	// 以启动时间作为初始incarnation，重启后的节点能够覆盖集群中关于它死亡的记录。
+	m.members[conf.Self] = &member{Member: Member{
+		Addr:        conf.Self,
+		State:       Alive,
+		Incarnation: uint64(time.Now().UnixNano()),
+		Weight:      conf.Weight,
+	}}
+	return m
+}
+
+// Join 与种子节点交换完整的成员列表，从而加入集群。没有种子节点，或至少一个种子节点同步成功时返回nil。
+func (m *Memberlist) Join(ctx context.Context, seeds ...string) error {
+	var errs []error
+	joined := false
+	for _, seed := range seeds {
+		if seed == m.conf.Self {
+			continue
+		}
+		if err := m.sync(ctx, seed); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		joined = true
+	}
+	if !joined && len(errs) > 0 {
+		return fmt.Errorf("join: %w", errors.Join(errs...))
+	}
+	m.notify()
+	return nil
+}
+
+// Start 启动后台的探测和同步，直到ctx被取消。
+func (m *Memberlist) Start(ctx context.Context) {
+	go func() {
+		probe := time.NewTicker(m.conf.ProbeInterval)
+		defer probe.Stop()
+		syncTicker := time.NewTicker(m.conf.SyncInterval)
+		defer syncTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-probe.C:
+				m.expireSuspects()
+				m.reapDead()
+				m.probe(ctx)
+			case <-syncTicker.C:
+				if peers := m.randomMembers(1, ""); len(peers) > 0 {
+					if err := m.sync(ctx, peers[0]); err != nil {
+						m.conf.Logf("[gossip %s] sync with %s failed: %v", m.conf.Self, peers[0], err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Members 按地址排序返回全部成员（包括本节点和死亡的成员）。
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]Member, 0, len(m.members))
+	for _, mem := range m.members {
+		members = append(members, mem.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
+
+// ServeHTTP 处理其他节点发来的ping、ping-req和sync消息。
+func (m *Memberlist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	msg := &pb.GossipMessage{}
+	if err := proto.Unmarshal(body, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.merge(msg.GetMembers())
+
+	var members []*pb.GossipMember
+	switch path.Base(r.URL.Path) {
+	case "ping":
+		members = m.piggyback()
+	case "ping-req":
+		if err := m.ping(r.Context(), msg.GetTarget()); err != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		members = m.piggyback()
+	case "sync":
+		members = m.all()
+	default:
+		http.Error(w, "unknown gossip message", http.StatusNotFound)
+		return
+	}
+	body, err = proto.Marshal(&pb.GossipMessage{From: m.conf.Self, Members: members})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 探测下一个成员：直接ping失败后请其他成员间接探测，仍然失败则标记为可疑。
+func (m *Memberlist) probe(ctx context.Context) {
+	target := m.nextTarget()
+	if target == "" {
+		return
+	}
+	if err := m.ping(ctx, target); err == nil {
+		return
+	}
+	helpers := m.randomMembers(m.conf.IndirectChecks, target)
+	if len(helpers) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, 2*m.conf.ProbeTimeout)
+		defer cancel()
+		results := make(chan error, len(helpers))
+		for _, helper := range helpers {
+			go func(helper string) {
+				res, err := m.send(ctx, helper, "ping-req", &pb.GossipMessage{From: m.conf.Self, Target: target, Members: m.piggyback()})
+				if err == nil {
+					m.merge(res.GetMembers())
+				}
+				results <- err
+			}(helper)
+		}
+		for range helpers {
+			if <-results == nil {
+				return
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	m.suspect(target)
+}
+
+// 直接探测addr，在ProbeTimeout内收到ack时返回nil。
+func (m *Memberlist) ping(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.conf.ProbeTimeout)
+	defer cancel()
+	res, err := m.send(ctx, addr, "ping", &pb.GossipMessage{From: m.conf.Self, Members: m.piggyback()})
+	if err != nil {
+		return err
+	}
+	m.merge(res.GetMembers())
+	return nil
+}
+
+// 与addr交换完整的成员列表。
+func (m *Memberlist) sync(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*m.conf.ProbeTimeout)
+	defer cancel()
+	res, err := m.send(ctx, addr, "sync", &pb.GossipMessage{From: m.conf.Self, Members: m.all()})
+	if err != nil {
+		return err
+	}
+	m.merge(res.GetMembers())
+	return nil
+}
+
+func (m *Memberlist) send(ctx context.Context, addr, op string, msg *pb.GossipMessage) (*pb.GossipMessage, error) {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+m.conf.Path+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := m.conf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err = io.ReadAll(io.LimitReader(res.Body, maxMessageBytes))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned: %v: %s", op, addr, res.Status, bytes.TrimSpace(body))
+	}
+	out := &pb.GossipMessage{}
+	if err := proto.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("decoding %s response: %v", op, err)
+	}
+	return out, nil
+}
+
+// 返回本轮下一个要探测的存活成员，每轮开始时随机打乱顺序。没有其他成员时返回""。
+func (m *Memberlist) nextTarget() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for attempts := 0; attempts < 2; attempts++ {
+		for m.probeIndex < len(m.probeOrder) {
+			addr := m.probeOrder[m.probeIndex]
+			m.probeIndex++
+			if mem, ok := m.members[addr]; ok && mem.State != Dead {
+				return addr
+			}
+		}
+		m.probeOrder = m.liveLocked("")
+		rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+		m.probeIndex = 0
+	}
+	return ""
+}
+
+// 随机返回至多k个存活的其他成员，不包括exclude。
+func (m *Memberlist) randomMembers(k int, exclude string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addrs := m.liveLocked(exclude)
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > k {
+		addrs = addrs[:k]
+	}
+	return addrs
+}
+
+// 存活（包括可疑）的其他成员，不包括本节点和exclude。调用者需持有m.mu。
+func (m *Memberlist) liveLocked(exclude string) []string {
+	addrs := make([]string, 0, len(m.members))
+	for addr, mem := range m.members {
+		if addr != m.conf.Self && addr != exclude && mem.State != Dead {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// 将探测失败的成员标记为可疑。
+func (m *Memberlist) suspect(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mem, ok := m.members[addr]; ok && mem.State == Alive {
+		m.apply(Member{Addr: addr, State: Suspect, Incarnation: mem.Incarnation, Weight: mem.Weight})
+	}
+}
+
+// 将可疑时间超过SuspicionTimeout的成员判定为死亡。
+func (m *Memberlist) expireSuspects() {
+	changed := false
+	m.mu.Lock()
+	for addr, mem := range m.members {
+		if mem.State == Suspect && time.Since(mem.suspectedAt) >= m.conf.SuspicionTimeout {
+			if m.apply(Member{Addr: addr, State: Dead, Incarnation: mem.Incarnation, Weight: mem.Weight}) {
+				changed = true
+			}
+		}
+	}
+	m.mu.Unlock()
+	if changed {
+		m.notify()
+	}
+}
+
+/*
+删除死亡超过DeadTimeout的成员，使成员列表不会随着节点的更替无限增长。
+之后再收到关于它的过时消息时，它会被重新加入并再次经过探测；重启的节点以更大的incarnation加入，不受影响。
+*/
+func (m *Memberlist) reapDead() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, mem := range m.members {
+		if mem.State == Dead && time.Since(mem.deadAt) >= m.conf.DeadTimeout {
+			delete(m.members, addr)
+		}
+	}
+}
+
+// 合并其他节点发来的成员状态，存活成员变化时调用OnChange。
+func (m *Memberlist) merge(updates []*pb.GossipMember) {
+	changed := false
+	m.mu.Lock()
+	for _, u := range updates {
+		if m.apply(Member{
+			Addr:        u.GetAddr(),
+			State:       State(u.GetState()),
+			Incarnation: u.GetIncarnation(),
+			Weight:      int(u.GetWeight()),
+		}) {
+			changed = true
+		}
+	}
+	m.mu.Unlock()
+	if changed {
+		m.notify()
+	}
+}
+
+/*
+按SWIM的规则应用一条状态变化，返回存活成员的集合或权重是否变化。调用者需持有m.mu。
+同一成员的消息按incarnation排序：alive只覆盖更小的incarnation；suspect覆盖相同incarnation的alive；
+dead覆盖相同或更小incarnation的alive和suspect。关于本节点的suspect和dead会被反驳：递增incarnation并传播alive。
+*/
+func (m *Memberlist) apply(u Member) bool {
+	if u.Addr == "" {
+		return false
+	}
+	if u.Addr == m.conf.Self {
+		self := m.members[u.Addr]
+		if u.State != Alive && u.Incarnation >= self.Incarnation {
+			self.Incarnation = u.Incarnation + 1
+			m.enqueue(self.Member)
+			m.conf.Logf("[gossip %s] refuting %s with incarnation %d", m.conf.Self, u.State, self.Incarnation)
+		}
+		return false
+	}
+	cur, ok := m.members[u.Addr]
+	if !ok {
+		m.members[u.Addr] = &member{Member: u, suspectedAt: time.Now(), deadAt: time.Now()}
+		if u.State == Dead {
+			return false // 只记录，用于忽略过时的alive消息
+		}
+		m.enqueue(u)
+		m.conf.Logf("[gossip %s] %s joined", m.conf.Self, u.Addr)
+		return true
+	}
+	if !overrides(u, cur.Member) {
+		return false
+	}
+	old := cur.Member
+	cur.Member = u
+	if u.State == Suspect && old.State != Suspect {
+		cur.suspectedAt = time.Now()
+	}
+	if u.State == Dead {
+		cur.deadAt = time.Now()
+	}
+	m.enqueue(u)
+	if old.State != u.State {
+		m.conf.Logf("[gossip %s] %s is %s (incarnation %d)", m.conf.Self, u.Addr, u.State, u.Incarnation)
+	}
+	return (old.State == Dead) != (u.State == Dead) || old.Weight != u.Weight
+}
+
+// 状态u是否比cur新。
+func overrides(u, cur Member) bool {
+	switch u.State {
+	case Alive:
+		return u.Incarnation > cur.Incarnation
+	case Suspect:
+		if cur.State == Dead {
+			return false
+		}
+		return u.Incarnation > cur.Incarnation || (cur.State == Alive && u.Incarnation == cur.Incarnation)
+	case Dead:
+		return cur.State != Dead && u.Incarnation >= cur.Incarnation
+	}
+	return false
+}
+
+// 将状态变化加入待传播队列，同一成员较旧的变化被替换。调用者需持有m.mu。
+func (m *Memberlist) enqueue(u Member) {
+	for _, b := range m.queue {
+		if b.member.Addr == u.Addr {
+			b.member, b.transmits = u, 0
+			return
+		}
+	}
+	m.queue = append(m.queue, &broadcast{member: u})
+}
+
+// 返回附带在消息中的状态：本节点的状态，以及转发次数最少的至多maxPiggyback条变化。
+func (m *Memberlist) piggyback() []*pb.GossipMember {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := []*pb.GossipMember{toPB(m.members[m.conf.Self].Member)}
+	sort.SliceStable(m.queue, func(i, j int) bool { return m.queue[i].transmits < m.queue[j].transmits })
+	limit := m.conf.RetransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+1))))
+	kept := m.queue[:0]
+	for i, b := range m.queue {
+		if i < maxPiggyback {
+			members = append(members, toPB(b.member))
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	m.queue = kept
+	return members
+}
+
+// 全部成员的状态，用于同步。
+func (m *Memberlist) all() []*pb.GossipMember {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]*pb.GossipMember, 0, len(m.members))
+	for _, mem := range m.members {
+		members = append(members, toPB(mem.Member))
+	}
+	return members
+}
+
+func toPB(mem Member) *pb.GossipMember {
+	return &pb.GossipMember{
+		Addr:        mem.Addr,
+		State:       int32(mem.State),
+		Incarnation: mem.Incarnation,
+		Weight:      int64(mem.Weight),
+	}
+}
+
+// 存活成员变化时调用OnChange。notifyMu保证调用是串行的，且总是传入最新的成员。
+func (m *Memberlist) notify() {
+	if m.conf.OnChange == nil {
+		return
+	}
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+	m.mu.Lock()
+	live := make(map[string]int, len(m.members))
+	for addr, mem := range m.members {
+		if mem.State != Dead {
+			live[addr] = mem.Weight
+		}
+	}
+	m.mu.Unlock()
+	if equal(live, m.notified) {
+		return
+	}
+	m.notified = live
+	m.conf.OnChange(live)
+}
+
+func equal(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
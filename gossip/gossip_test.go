@@ -0,0 +1,184 @@
+package gossip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+// 在本地回环地址上启动的一个节点，记录OnChange最近一次传入的成员。
+type testNode struct {
+	server *httptest.Server
+	list   *Memberlist
+
+	mu      sync.Mutex
+	members map[string]int
+}
+
+func startNode(t *testing.T, ctx context.Context, seeds ...string) *testNode {
+	n := &testNode{}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.list.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.server.Close)
+	n.list = New(Config{
+		Self:             n.server.URL,
+		ProbeInterval:    10 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		SyncInterval:     200 * time.Millisecond,
+		OnChange: func(members map[string]int) {
+			n.mu.Lock()
+			n.members = members
+			n.mu.Unlock()
+		},
+		Logf: t.Logf,
+	})
+	if err := n.list.Join(ctx, seeds...); err != nil {
+		t.Fatal(err)
+	}
+	n.list.Start(ctx)
+	return n
+}
+
+func (n *testNode) sees(addrs ...string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.members) != len(addrs) {
+		return false
+	}
+	for _, addr := range addrs {
+		if _, ok := n.members[addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJoinAndFailureDetection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seed := startNode(t, ctx)
+	nodes := []*testNode{seed}
+	failedCtx, stop := context.WithCancel(ctx)
+	for i := 0; i < 3; i++ {
+		nodeCtx := ctx
+		if i == 1 {
+			nodeCtx = failedCtx
+		}
+		nodes = append(nodes, startNode(t, nodeCtx, seed.server.URL)) // 每个新节点只知道种子节点
+	}
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.server.URL
+	}
+	for _, n := range nodes {
+		waitFor(t, n.server.URL+" to see all nodes", func() bool { return n.sees(addrs...) })
+	}
+
+	// 停止一个节点后，其余节点经过可疑阶段将它判定为死亡。
+	stop()
+	nodes[2].server.CloseClientConnections()
+	nodes[2].server.Close()
+	alive := []string{addrs[0], addrs[1], addrs[3]}
+	for _, n := range []*testNode{nodes[0], nodes[1], nodes[3]} {
+		waitFor(t, n.server.URL+" to remove the failed node", func() bool { return n.sees(alive...) })
+	}
+	for _, m := range nodes[0].list.Members() {
+		if m.Addr == addrs[2] && m.State != Dead {
+			t.Fatalf("failed node is %s, want dead", m.State)
+		}
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := startNode(t, ctx)
+	b := startNode(t, ctx, a.server.URL)
+	waitFor(t, "a to see b", func() bool { return a.sees(a.server.URL, b.server.URL) })
+
+	// a错误地怀疑b，b收到消息后以更大的incarnation反驳，a不会将它判定为死亡。
+	a.list.suspect(b.server.URL)
+	waitFor(t, "b to refute the suspicion", func() bool {
+		for _, m := range a.list.Members() {
+			if m.Addr == b.server.URL {
+				return m.State == Alive
+			}
+		}
+		return false
+	})
+	time.Sleep(200 * time.Millisecond)
+	if !a.sees(a.server.URL, b.server.URL) {
+		t.Fatal("b should stay in the cluster after refuting")
+	}
+}
+
+// 死亡的成员在DeadTimeout后被删除。
+func TestReapDead(t *testing.T) {
+	m := New(Config{Self: "http://self", DeadTimeout: 50 * time.Millisecond, Logf: t.Logf})
+	m.merge([]*pb.GossipMember{
+		{Addr: "http://alive", State: int32(Alive), Incarnation: 1},
+		{Addr: "http://dead", State: int32(Dead), Incarnation: 1},
+	})
+	m.reapDead()
+	if len(m.Members()) != 3 {
+		t.Fatalf("members = %v, the dead member should be kept for DeadTimeout", m.Members())
+	}
+	time.Sleep(60 * time.Millisecond)
+	m.reapDead()
+	for _, mem := range m.Members() {
+		if mem.Addr == "http://dead" {
+			t.Fatalf("dead member should be reaped, got %v", m.Members())
+		}
+	}
+	if len(m.Members()) != 2 {
+		t.Fatalf("members = %v, want self and the alive member", m.Members())
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	m := New(Config{Self: "http://self", Logf: t.Logf})
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gossip/ping", strings.NewReader(strings.Repeat("x", maxMessageBytes+1))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		u, cur Member
+		want   bool
+	}{
+		{Member{State: Alive, Incarnation: 2}, Member{State: Suspect, Incarnation: 1}, true},
+		{Member{State: Alive, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, false},
+		{Member{State: Suspect, Incarnation: 1}, Member{State: Alive, Incarnation: 1}, true},
+		{Member{State: Suspect, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, false},
+		{Member{State: Dead, Incarnation: 1}, Member{State: Alive, Incarnation: 2}, false},
+		{Member{State: Dead, Incarnation: 2}, Member{State: Suspect, Incarnation: 2}, true},
+		{Member{State: Suspect, Incarnation: 3}, Member{State: Dead, Incarnation: 2}, false},
+		{Member{State: Alive, Incarnation: 3}, Member{State: Dead, Incarnation: 2}, true},
+	}
+	for _, tt := range tests {
+		if got := overrides(tt.u, tt.cur); got != tt.want {
+			t.Errorf("%s(%d) overrides %s(%d) = %v, want %v", tt.u.State, tt.u.Incarnation, tt.cur.State, tt.cur.Incarnation, got, tt.want)
+		}
+	}
+}
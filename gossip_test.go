@@ -0,0 +1,71 @@
+package mycache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mycache/gossip"
+)
+
+// 一致性哈希中的节点。
+func ringNodes(p *HTTPPool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers.Nodes()
+}
+
+func TestHTTPPoolGossip(t *testing.T) {
+	conf := gossip.Config{
+		ProbeInterval:    10 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		Logf:             t.Logf,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pools []*HTTPPool
+	var servers []*httptest.Server
+	var stopLast context.CancelFunc
+	for i := 0; i < 3; i++ {
+		var pool *HTTPPool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pool.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		pool = NewHTTPPool(server.URL)
+		nodeCtx := ctx
+		if i == 2 {
+			nodeCtx, stopLast = context.WithCancel(ctx)
+		}
+		var seeds []string
+		if i > 0 {
+			seeds = []string{servers[0].URL}
+		}
+		c := conf
+		c.Weight = i + 1
+		if _, err := pool.StartGossip(nodeCtx, c, seeds...); err != nil {
+			t.Fatal(err)
+		}
+		pools = append(pools, pool)
+		servers = append(servers, server)
+	}
+
+	for _, pool := range pools {
+		waitFor(t, pool.self+" to learn all peers", func() bool { return len(ringNodes(pool)) == 3 })
+	}
+	pools[0].mu.Lock()
+	weight := pools[0].peers.Weight(servers[2].URL)
+	pools[0].mu.Unlock()
+	if weight != 3 {
+		t.Fatalf("weight of the third node = %d, want 3 as announced by gossip", weight)
+	}
+
+	stopLast()
+	servers[2].Close()
+	for _, pool := range pools[:2] {
+		waitFor(t, pool.self+" to drop the stopped peer", func() bool { return len(ringNodes(pool)) == 2 })
+	}
+}
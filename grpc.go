@@ -0,0 +1,283 @@
+package mycache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"mycache/consistenthash"
+	pb "mycache/mycachepb"
+)
+
+/*
+GRPCPool 与 HTTPPool 相同，实现了 PeerPicker，但节点之间通过gRPC调用mycachepb.proto中声明的GroupCache服务通信。
+可以代替 HTTPPool 传给 Group.RegisterPeers。
+服务端部分：RegisterServer(s) 将GroupCache服务注册到 grpc.Server 上，由调用者负责监听端口和启动服务。
+客户端部分：Set(peers ...string) 传入所有节点（包括本节点）的地址，如"localhost:8001"；每个远程节点对应一个 grpcGetter。
+*/
+type GRPCPool struct {
+	self        string            // 本节点的地址，如"localhost:8001"，必须与传给Set的地址写法一致
+	dialOpts    []grpc.DialOption // 连接远程节点时使用的选项
+	mu          sync.Mutex        // guards peers and grpcGetters
+	peers       *consistenthash.Map
+	grpcGetters map[string]*grpcGetter   // 远程节点地址 -> 【数据获得器】
+	getGroup    func(name string) *Group // 按名称查找本节点的Group，为nil时使用GetGroup；测试中用于在同一进程内模拟多个节点
+	// 向远程节点发出的每次请求的超时时间，默认为defaultPeerTimeout，小于0表示不设超时。需在第一次调用Set之前设置。
+	Timeout time.Duration
+}
+
+// NewGRPCPool 新建gRPC节点池。opts为空时使用不加密的连接。
+func NewGRPCPool(self string, opts ...grpc.DialOption) *GRPCPool {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &GRPCPool{
+		self:     self,
+		dialOpts: opts,
+		Timeout:  defaultPeerTimeout,
+	}
+}
+
+// Log info with server name
+func (p *GRPCPool) Log(format string, v ...any) {
+	log.Printf("[gRPC Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// 服务器部分：
+
+// RegisterServer 将GroupCache服务注册到s上。
+func (p *GRPCPool) RegisterServer(s *grpc.Server) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{pool: p})
+}
+
+// 节点之间转发的Set请求带有该metadata，收到后直接写入本地，不再选择节点，与HTTPPool的peerForwardHeader相同。
+const peerForwardMetadata = "x-mycache-peer-forward"
+
+// grpcServer 实现 pb.GroupCacheServer，响应其他节点的请求。
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	pool *GRPCPool
+}
+
+func (p *GRPCPool) lookupGroup(name string) (*Group, error) {
+	var group *Group
+	if p.getGroup != nil {
+		group = p.getGroup(name)
+	} else {
+		group = GetGroup(name)
+	}
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group: %s", name)
+	}
+	return group, nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.Request) (*pb.KVResponse, error) {
+	s.pool.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := s.pool.lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	view, err := group.getForPeer(ctx, in.GetKey()) // 请求都来自其他节点，见getForPeer
+	if err != nil {
+		return nil, err
+	}
+	return &pb.KVResponse{Value: view.ByteSlice(), Timestamp: view.version, Expire: view.expireMillis()}, nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*emptypb.Empty, error) {
+	s.pool.Log("Set %s/%s", in.GetGroup(), in.GetKey())
+	group, err := s.pool.lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	set := group.Set
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(peerForwardMetadata)) > 0 {
+		set = group.setForPeer
+	}
+	if err := set(in.GetKey(), in.GetValue()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Delete 只删除本节点的数据，广播由发起删除的节点负责。
+func (s *grpcServer) Delete(ctx context.Context, in *pb.Request) (*emptypb.Empty, error) {
+	s.pool.Log("Delete %s/%s", in.GetGroup(), in.GetKey())
+	group, err := s.pool.lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if err := group.deleteLocally(in.GetKey()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) GetMulti(ctx context.Context, in *pb.MultiRequest) (*pb.MultiResponse, error) {
+	s.pool.Log("GetMulti %s %d keys", in.GetGroup(), len(in.GetKeys()))
+	group, err := s.pool.lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	return group.serveMulti(ctx, in.GetKeys()), nil
+}
+
+// 客户端部分：
+
+/*
+Set 传入所有节点（包括本节点）的地址，重建一致性哈希。
+仍在列表中的节点复用原有连接，被移除的节点的连接会被关闭，其请求耗时指标也被删除。
+*/
+func (p *GRPCPool) Set(peerAddrs ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	getters := make(map[string]*grpcGetter, len(peerAddrs))
+	for _, addr := range peerAddrs {
+		if addr == p.self {
+			continue
+		}
+		if g, ok := p.grpcGetters[addr]; ok {
+			getters[addr] = g
+			continue
+		}
+		conn, err := grpc.NewClient(addr, p.dialOpts...)
+		if err != nil {
+			for addr, g := range getters {
+				if _, ok := p.grpcGetters[addr]; !ok {
+					g.conn.Close()
+				}
+			}
+			return fmt.Errorf("peer %s: %w", addr, err)
+		}
+		getters[addr] = &grpcGetter{addr: addr, conn: conn, client: pb.NewGroupCacheClient(conn), timeout: p.Timeout}
+	}
+	for addr, g := range p.grpcGetters {
+		if _, ok := getters[addr]; !ok {
+			g.conn.Close()
+			peerRequestDuration.DeleteMatching("peer", addr)
+		}
+	}
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(peerAddrs...)
+	p.grpcGetters = getters
+	return nil
+}
+
+// PickPeer 返回key对应的远程节点的【数据获得器】；key由本节点负责时返回false。
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Pick peer %s", peer)
+		return p.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// 返回全部远程节点的【数据获得器】，不包括本节点。
+func (p *GRPCPool) GetAll() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	getters := make([]PeerGetter, 0, len(p.grpcGetters))
+	for _, getter := range p.grpcGetters {
+		getters = append(getters, getter)
+	}
+	return getters
+}
+
+// Close 关闭与全部远程节点的连接，并删除它们的请求耗时指标。
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, g := range p.grpcGetters {
+		g.conn.Close()
+		peerRequestDuration.DeleteMatching("peer", addr)
+	}
+	p.grpcGetters = nil
+	p.peers = nil
+	return nil
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+
+// grpcGetter 实现 PeerGetter 接口。——基于gRPC的【数据获得器】，每个远程节点一个连接。
+type grpcGetter struct {
+	addr    string // 远程节点的地址，如"localhost:8002"
+	conn    *grpc.ClientConn
+	client  pb.GroupCacheClient
+	timeout time.Duration // 每次请求的超时时间，小于等于0表示不设超时
+}
+
+// 为一次请求加上超时时间。
+func (h *grpcGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout > 0 {
+		return context.WithTimeout(ctx, h.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (h *grpcGetter) Get(in *pb.Request, out *pb.KVResponse) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+func (h *grpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.KVResponse) error {
+	defer peerRequestDuration.With(h.addr, "get").ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	res, err := h.client.Get(ctx, in)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", h.addr, err)
+	}
+	out.Value, out.Timestamp, out.Expire = res.GetValue(), res.GetTimestamp(), res.GetExpire()
+	return nil
+}
+
+// Set 将值写入远程节点，metadata peerForwardMetadata告知对方直接写入本地。
+func (h *grpcGetter) Set(in *pb.SetRequest) error {
+	defer peerRequestDuration.With(h.addr, "set").ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(context.Background())
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, peerForwardMetadata, "1")
+	if _, err := h.client.Set(ctx, in); err != nil {
+		return fmt.Errorf("peer %s: %w", h.addr, err)
+	}
+	return nil
+}
+
+func (h *grpcGetter) Delete(in *pb.Request) error {
+	defer peerRequestDuration.With(h.addr, "delete").ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(context.Background())
+	defer cancel()
+	if _, err := h.client.Delete(ctx, in); err != nil {
+		return fmt.Errorf("peer %s: %w", h.addr, err)
+	}
+	return nil
+}
+
+func (h *grpcGetter) GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) error {
+	defer peerRequestDuration.With(h.addr, "get_multi").ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	res, err := h.client.GetMulti(ctx, in)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", h.addr, err)
+	}
+	out.Results = res.GetResults()
+	return nil
+}
+
+var _ ContextPeerGetter = (*grpcGetter)(nil)
+var _ PeerBatchGetter = (*grpcGetter)(nil)
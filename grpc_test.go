@@ -0,0 +1,158 @@
+package mycache
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "mycache/mycachepb"
+)
+
+/*
+在本地回环地址上启动一个只提供GroupCache服务的gRPC服务器，返回其地址。
+getGroup不为nil时，服务器通过它查找Group，用于模拟另一个节点，见newRemoteNode。
+*/
+func startGRPCServer(t *testing.T, getGroup func(name string) *Group) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pool := NewGRPCPool(lis.Addr().String())
+	pool.getGroup = getGroup
+	pool.RegisterServer(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCPool(t *testing.T) {
+	// 远程节点：没有注册PeerPicker的Group。
+	remote := newTestGroup(t, Conf{Name: "grpc-remote"}, 2<<10, &batchGetter{})
+	remoteAddr := startGRPCServer(t, func(name string) *Group {
+		if name == "grpc-local" {
+			return remote
+		}
+		return nil
+	})
+
+	g := newTestGroup(t, Conf{Name: "grpc-local"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	}))
+	pool := NewGRPCPool("127.0.0.1:1")
+	defer pool.Close()
+	if err := pool.Set("127.0.0.1:1", remoteAddr); err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterPeers(pool)
+
+	var remoteKey, localKey string
+	for i := 0; remoteKey == "" || localKey == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := pool.PickPeer(key); ok {
+			remoteKey = key
+		} else {
+			localKey = key
+		}
+	}
+
+	if v, err := g.Get(remoteKey); err != nil || v.String() != "v-"+remoteKey {
+		t.Fatalf("get %s = %q, %v", remoteKey, v.String(), err)
+	}
+	if v, err := g.Get(localKey); err != nil || v.String() != "local-"+localKey {
+		t.Fatalf("get %s = %q, %v", localKey, v.String(), err)
+	}
+
+	values, errs := g.GetMulti([]string{remoteKey, "missing-" + remoteKey})
+	if values[0].String() != "v-"+remoteKey || errs[0] != nil {
+		t.Fatalf("get multi %s = %q, %v", remoteKey, values[0].String(), errs[0])
+	}
+
+	if err := g.Set(remoteKey, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := remote.Get(remoteKey); err != nil || v.String() != "new" {
+		t.Fatalf("remote get %s after set = %q, %v", remoteKey, v.String(), err)
+	}
+
+	if err := g.Delete(remoteKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := remote.mainCache.get(remoteKey); ok {
+		t.Fatalf("%s should be deleted on the remote peer", remoteKey)
+	}
+
+	peer, _ := pool.PickPeer(remoteKey)
+	err := peer.Get(&pb.Request{Group: "no-such-group", Key: "a"}, &pb.KVResponse{})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("get from unknown group: %v, want NotFound", err)
+	}
+}
+
+func TestGRPCGetContextCancel(t *testing.T) {
+	newTestGroup(t, Conf{Name: "grpc-cancel"}, 2<<10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	addr := startGRPCServer(t, nil)
+	pool := NewGRPCPool("127.0.0.1:1")
+	defer pool.Close()
+	pool.Set(addr)
+	peer, ok := pool.PickPeer("a")
+	if !ok {
+		t.Fatal("the only peer should be picked")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := peer.(ContextPeerGetter).GetContext(ctx, &pb.Request{Group: "grpc-cancel", Key: "a"}, &pb.KVResponse{})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+}
+
+// GetContext返回值的版本和过期时间，与httpGetter相同。
+func TestGRPCGetVersionAndExpire(t *testing.T) {
+	newTestGroup(t, Conf{Name: "grpc-expire", DefaultTTL: time.Minute}, 2<<10, &batchGetter{})
+	addr := startGRPCServer(t, nil)
+	pool := NewGRPCPool("127.0.0.1:1")
+	defer pool.Close()
+	pool.Set(addr)
+	peer, _ := pool.PickPeer("a")
+	res := &pb.KVResponse{}
+	if err := peer.Get(&pb.Request{Group: "grpc-expire", Key: "a"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "v-a" || res.Timestamp == 0 || res.Expire <= time.Now().UnixMilli() {
+		t.Fatalf("got value %q, timestamp %d, expire %d", res.Value, res.Timestamp, res.Expire)
+	}
+}
+
+// 远程节点没有响应时，Set和Delete在超时后返回，不会一直阻塞。
+func TestGRPCPeerTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0") // 只监听，不处理任何请求
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	pool := NewGRPCPool("127.0.0.1:1")
+	pool.Timeout = 50 * time.Millisecond
+	defer pool.Close()
+	pool.Set(lis.Addr().String())
+	peer, _ := pool.PickPeer("a")
+	start := time.Now()
+	if err := peer.Set(&pb.SetRequest{Group: "g", Key: "a"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("set: got %v, want DeadlineExceeded", err)
+	}
+	if err := peer.Delete(&pb.Request{Group: "g", Key: "a"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("delete: got %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("requests took %v", d)
+	}
+}
@@ -0,0 +1,120 @@
+package mycache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 一个远程节点的健康状态。
+type peerHealth struct {
+	up          bool
+	successes   int   // 连续探测成功的次数
+	failures    int   // 连续探测失败的次数
+	transitions int64 // 在健康与不健康之间切换的次数
+}
+
+/*
+StartHealthCheck 启动后台健康检查，每隔interval并发探测一次全部远程节点的 /_mycache_internal/health，直到ctx被取消。
+连续UnhealthyThreshold次探测失败的节点被移出一致性哈希，它负责的key暂时由其余节点接管；
+节点恢复后连续HealthyThreshold次探测成功，重新加入一致性哈希。状态切换会记录日志，并通过metrics导出。
+*/
+func (p *HTTPPool) StartHealthCheck(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.checkHealth(ctx)
+			}
+		}
+	}()
+}
+
+// 探测一次全部远程节点，并根据结果更新一致性哈希。
+func (p *HTTPPool) checkHealth(ctx context.Context) {
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.health))
+	for peer := range p.health {
+		if peer != p.self {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	results := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			results[i] = p.probe(ctx, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changed := false
+	for i, peer := range peers {
+		h, ok := p.health[peer]
+		if !ok {
+			continue // 探测期间节点已被Set或RemovePeers移除
+		}
+		if results[i] == nil {
+			h.successes++
+			h.failures = 0
+			if !h.up && h.successes >= p.opts.HealthyThreshold {
+				h.up = true
+				h.transitions++
+				p.peers.AddWeighted(peer, p.weights[peer])
+				changed = true
+				p.Log("peer %s is up, adding it back to the ring", peer)
+			}
+		} else {
+			h.failures++
+			h.successes = 0
+			if h.up && h.failures >= p.opts.UnhealthyThreshold {
+				h.up = false
+				h.transitions++
+				p.peers.Remove(peer)
+				changed = true
+				p.Log("peer %s is down, removing it from the ring: %v", peer, results[i])
+			}
+		}
+	}
+	if changed {
+		p.ringChanged()
+	}
+}
+
+// 探测一个远程节点，返回nil表示节点健康。
+func (p *HTTPPool) probe(ctx context.Context, peer string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.HealthCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+internalBasePath+"health", nil)
+	if err != nil {
+		return err
+	}
+	client := p.opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned: %v", res.Status)
+	}
+	return nil
+}
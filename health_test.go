@@ -0,0 +1,79 @@
+package mycache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 统计key被分配到的远程节点。
+func ownedPeers(p *HTTPPool) map[PeerGetter]bool {
+	owners := make(map[PeerGetter]bool)
+	for i := 0; i < 1000; i++ {
+		if peer, ok := p.PickPeer(strconv.Itoa(i)); ok {
+			owners[peer] = true
+		}
+	}
+	return owners
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	s1 := httptest.NewServer(NewHTTPPool("http://s1"))
+	defer s1.Close()
+	s2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		NewHTTPPool("http://s2").ServeHTTP(w, r)
+	}))
+	defer s2.Close()
+
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{UnhealthyThreshold: 2})
+	pool.Set("http://self", s1.URL, s2.URL)
+	pool.mu.Lock()
+	getter2 := pool.httpGetters[s2.URL]
+	pool.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.StartHealthCheck(ctx, 5*time.Millisecond)
+
+	if !ownedPeers(pool)[getter2] {
+		t.Fatal("s2 should own some keys")
+	}
+
+	down.Store(true)
+	waitFor(t, "s2 to leave the ring", func() bool { return !ownedPeers(pool)[getter2] })
+	if n := len(pool.GetAll()); n != 1 {
+		t.Fatalf("GetAll returned %d peers, want 1", n)
+	}
+	for _, state := range pool.peerStates() {
+		if state.Up != (state.Peer == s1.URL) {
+			t.Fatalf("peer %s up = %v, want s1 up and s2 down", state.Peer, state.Up)
+		}
+	}
+
+	down.Store(false)
+	waitFor(t, "s2 to rejoin the ring", func() bool { return ownedPeers(pool)[getter2] })
+	pool.mu.Lock()
+	transitions := pool.health[s2.URL].transitions
+	pool.mu.Unlock()
+	if transitions != 2 {
+		t.Fatalf("s2 has %d transitions, want 2", transitions)
+	}
+}
@@ -1,17 +1,26 @@
 package mycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
 	"mycache/consistenthash"
+	"mycache/gossip"
+	"mycache/metrics"
 	pb "mycache/mycachepb"
 )
 
@@ -19,8 +28,36 @@ const (
 	defaultBasePath  = "/_mycache/"
 	internalBasePath = "/_mycache_internal/"
 	defaultReplicas  = 50
+
+	defaultPeerTimeout      = 3 * time.Second
+	defaultPeerRetries      = 2
+	defaultRetryBackoff     = 50 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+
+	defaultRebalanceRate = 1000
+	defaultMaxBodyBytes  = 64 << 20
+
+	defaultHealthCheckTimeout = time.Second
+	defaultUnhealthyThreshold = 2
+	defaultHealthyThreshold   = 1
+	// 节点之间转发的删除请求带有该请求头，收到后只删除本地数据，不再广播。
+	peerDeleteHeader = "X-Mycache-Peer-Delete"
+	// 副本读写请求带有该请求头：GET只读取本地保存的副本，PUT以peerTimestampHeader为版本写入本地副本。
+	peerReplicaHeader   = "X-Mycache-Peer-Replica"
+	peerTimestampHeader = "X-Mycache-Timestamp"
+	peerExpireHeader    = "X-Mycache-Expire" // 副本的过期时间（毫秒时间戳），没有时省略
+	// 其他节点转发来的GET、PUT和批量获取请求带有该请求头：发出请求的节点已经选择了本节点，由本节点直接处理，不再选择节点。
+	peerForwardHeader = "X-Mycache-Peer-Forward"
 )
 
+// internalBasePath下由HTTPPool自己处理的路径，不能用作Group的名称，否则该Group的内部接口无法访问。
+var reservedGroupNames = map[string]bool{
+	"metrics": true,
+	"health":  true,
+	"gossip":  true,
+}
+
 /*
 HTTPPool implements PeerPicker for a pool of HTTP peers.
 HTTPPool 只有 2 个参数，一个是 self，用来记录自己的地址，包括主机名/IP 和端口。
@@ -36,18 +73,105 @@ PickPeer(key string) (PeerGetter, bool)： 返回键值对应的【数据获得
 type HTTPPool struct {
 	self        string // 本服务节点的URL, e.g. "https://example.net:8000"
 	basePath    string
-	mu          sync.Mutex             // guards peers and httpGetters
-	peers       *consistenthash.Map    // 一致性哈希算法的字典，用来根据具体的 key 选择节点。peers是键值指向IP地址，如"小明"→"http://10.0.0.2:8008"。
-	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"。httpGetters是一个IP地址指向一个【数据获得器】。
+	opts        HTTPPoolOptions
+	mu          sync.Mutex               // guards peers and httpGetters
+	peers       consistenthash.Placement // 一致性哈希算法的字典，用来根据具体的 key 选择节点。peers是键值指向IP地址，如"小明"→"http://10.0.0.2:8008"。
+	httpGetters map[string]*httpGetter   // keyed by e.g. "http://10.0.0.2:8008"。httpGetters是一个IP地址指向一个【数据获得器】。
 	// 即每一个远程节点（的IP地址）指向一个 httpGetter。httpGetter 与远程节点的地址 baseURL 有关。
+	health  map[string]*peerHealth // 全部节点（包括本节点）的健康状态，由健康检查更新。健康的节点组成一致性哈希
+	weights map[string]int         // 全部节点在一致性哈希中的权重
+	gossip  *gossip.Memberlist     // 由StartGossip启动的成员管理，为nil时节点列表由Set等方法维护
+
+	getGroup  func(name string) *Group // 按名称查找本节点的Group，为nil时使用GetGroup；测试中用于在同一进程内模拟多个节点
+	inflight  AtomicInt                // 本节点正在处理的来自其他节点的请求数
+	rebalance rebalancer               // 节点变化后将本节点不再负责的key迁移到新节点
+}
+
+/*
+HTTPPoolOptions 是HTTPPool的可选配置。数值字段为0时使用默认值，小于0时关闭对应的功能。
+向远程节点发出的请求因网络错误、超时或502/503/504失败时，最多重试Retries次，
+第n次重试前随机等待[0, RetryBackoff*2^(n-1))，避免多个节点同时重试。
+连续失败BreakerThreshold次后，该节点的熔断器断开，BreakerCooldown时间内的请求直接失败，由Group回退到本地导入。
+节点返回的其他错误（如key不存在）说明节点本身正常，既不重试也不计入失败。
+*/
+type HTTPPoolOptions struct {
+	BasePath         string                   // 节点间通讯地址的前缀，默认为defaultBasePath
+	Replicas         int                      // 每个节点在一致性哈希中的虚拟节点数，默认为defaultReplicas
+	Placement        consistenthash.Algorithm // 选择节点的算法，默认为哈希环consistenthash.RingHash
+	LoadBound        float64                  // Placement为consistenthash.BoundedLoads时，节点负载不超过平均值的(1+LoadBound)倍，默认为consistenthash.DefaultEpsilon
+	ReplicaCount     int                      // 每个key的副本数（包括主节点），主节点获取失败时依次尝试副本节点，默认为1即不使用副本
+	Timeout          time.Duration            // 每次请求的超时时间，默认为defaultPeerTimeout
+	Retries          int                      // 最大重试次数，默认为defaultPeerRetries
+	RetryBackoff     time.Duration            // 第一次重试前等待时间的上限，默认为defaultRetryBackoff
+	BreakerThreshold int                      // 熔断器断开前允许的连续失败次数，默认为defaultBreakerThreshold
+	BreakerCooldown  time.Duration            // 熔断器断开后，经过多久放行一次试探请求，默认为defaultBreakerCooldown
+	Client           *http.Client             // 发出请求使用的客户端，默认为http.DefaultClient
+	RebalanceRate    int                      // 节点变化后每秒最多迁移的key数，默认为defaultRebalanceRate，小于0时不迁移
+	MaxBodyBytes     int64                    // 收到的请求体的大小上限，超过时返回413，默认为defaultMaxBodyBytes，小于0时不限制
+
+	// 以下配置用于StartHealthCheck启动的健康检查。
+	HealthCheckTimeout time.Duration // 每次探测的超时时间，默认为defaultHealthCheckTimeout
+	UnhealthyThreshold int           // 连续探测失败多少次后将节点移出一致性哈希，默认为defaultUnhealthyThreshold
+	HealthyThreshold   int           // 连续探测成功多少次后将节点重新加入，默认为defaultHealthyThreshold
 }
 
 // NewHTTPPool initializes an HTTP pool of peers.
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self, // 如"localhost:9999"
-		basePath: defaultBasePath,
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts 与 NewHTTPPool 相同，但可以通过o指定超时、重试和熔断等配置，o为nil时全部使用默认值。
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	p := &HTTPPool{
+		self: self, // 如"localhost:9999"
+	}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.BasePath == "" {
+		p.opts.BasePath = defaultBasePath
 	}
+	if p.opts.MaxBodyBytes == 0 {
+		p.opts.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if p.opts.Replicas <= 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.ReplicaCount <= 0 {
+		p.opts.ReplicaCount = 1
+	}
+	if p.opts.Timeout == 0 {
+		p.opts.Timeout = defaultPeerTimeout
+	}
+	if p.opts.Retries == 0 {
+		p.opts.Retries = defaultPeerRetries
+	}
+	if p.opts.RetryBackoff <= 0 {
+		p.opts.RetryBackoff = defaultRetryBackoff
+	}
+	if p.opts.BreakerThreshold == 0 {
+		p.opts.BreakerThreshold = defaultBreakerThreshold
+	}
+	if p.opts.BreakerCooldown <= 0 {
+		p.opts.BreakerCooldown = defaultBreakerCooldown
+	}
+	if p.opts.RebalanceRate == 0 {
+		p.opts.RebalanceRate = defaultRebalanceRate
+	}
+	if p.opts.HealthCheckTimeout <= 0 {
+		p.opts.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if p.opts.UnhealthyThreshold <= 0 {
+		p.opts.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if p.opts.HealthyThreshold <= 0 {
+		p.opts.HealthyThreshold = defaultHealthyThreshold
+	}
+	if _, err := consistenthash.NewPlacement(p.opts.Placement, p.opts.Replicas); err != nil {
+		panic(err)
+	}
+	p.basePath = p.opts.BasePath
+	return p
 }
 
 // Log info with server name
@@ -71,8 +195,14 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		groupName := parts[0]
-		if len(parts) == 1 && r.Method == "GET" {
+		if len(parts) == 1 && groupName == "metrics" && r.Method == "GET" {
+			p.ServeMetrics(w) // GET /_mycache_internal/metrics，Prometheus文本格式的指标
+		} else if len(parts) == 1 && groupName == "health" && r.Method == "GET" {
+			w.Write([]byte("ok")) // GET /_mycache_internal/health，供其他节点的健康检查探测
+		} else if len(parts) == 1 && r.Method == "GET" {
 			p.ServeInternalInfo(w, groupName)
+		} else if len(parts) == 2 && groupName == "gossip" {
+			p.serveGossip(w, r) // POST /_mycache_internal/gossip/{ping,ping-req,sync}，节点之间的成员管理消息
 		} else if len(parts) == 2 && parts[1] == "backup" && r.Method == "POST" {
 			p.ServeInternalBackup(w, groupName)
 		} else {
@@ -87,7 +217,13 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path[len(p.basePath):]是scores/Tom
 		/<basepath>/<groupname>/<key> required
 	*/
+	p.inflight.Add(1)
+	defer p.inflight.Add(-1)
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2) // parts[0]是scores，parts[1]是Tom
+	if len(parts) == 1 && r.Method == "POST" {
+		p.ServeMulti(w, r, parts[0]) // POST /<basepath>/<groupname> 批量获取
+		return
+	}
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest) // 如果parts不是group+key，则非法
 		return
@@ -98,14 +234,27 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *HTTPPool) ServeKey(w http.ResponseWriter, r *http.Request, groupName, key string) {
-	group := GetGroup(groupName)
+	group := p.group(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
 	if r.Method == "GET" {
-		view, err := group.Get(key)
-		body, err := proto.Marshal(&pb.KVResponse{Value: view.ByteSlice()})
+		var view ByteView
+		var err error
+		if r.Header.Get(peerReplicaHeader) != "" {
+			view, _ = group.mainCache.getVersioned(key) // 没有副本时返回版本为0的空值
+		} else if r.Header.Get(peerForwardHeader) != "" {
+			view, err = group.getForPeer(r.Context(), key)
+		} else {
+			// 请求方断开连接或超时时，r.Context()随之取消。
+			view, err = group.GetContext(r.Context(), key)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, err := proto.Marshal(&pb.KVResponse{Value: view.ByteSlice(), Timestamp: view.version, Expire: view.expireMillis()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -114,9 +263,47 @@ func (p *HTTPPool) ServeKey(w http.ResponseWriter, r *http.Request, groupName, k
 		w.Write(body) // 按事先协商好：body必须是序列化的pb.KVResponse格式的数据。
 		return
 	}
+	if r.Method == "PUT" {
+		// 请求体就是key对应的值。
+		value, ok := p.readBody(w, r)
+		if !ok {
+			return
+		}
+		var err error
+		if r.Header.Get(peerReplicaHeader) != "" {
+			var version uint64
+			version, err = strconv.ParseUint(r.Header.Get(peerTimestampHeader), 10, 64)
+			if err != nil {
+				http.Error(w, "bad timestamp: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			var expire int64
+			if h := r.Header.Get(peerExpireHeader); h != "" {
+				if expire, err = strconv.ParseInt(h, 10, 64); err != nil {
+					http.Error(w, "bad expire: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			err = group.setReplicaLocally(key, value, version, expireFromMillis(expire))
+		} else if r.Header.Get(peerForwardHeader) != "" {
+			err = group.setForPeer(key, value)
+		} else {
+			err = group.Set(key, value)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		return
+	}
 	if r.Method == "DELETE" {
 		fmt.Println("delete key: ", key)
-		err := group.Delete(key)
+		var err error
+		if r.Header.Get(peerDeleteHeader) != "" {
+			err = group.deleteLocally(key)
+		} else {
+			err = group.Delete(key)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -125,17 +312,94 @@ func (p *HTTPPool) ServeKey(w http.ResponseWriter, r *http.Request, groupName, k
 	}
 }
 
+// 查找本节点上名为name的Group，不存在时返回nil。
+func (p *HTTPPool) group(name string) *Group {
+	if p.getGroup != nil {
+		return p.getGroup(name)
+	}
+	return GetGroup(name)
+}
+
+// 读取请求体，最多MaxBodyBytes字节。读取失败时已向w写入错误响应，返回false。
+func (p *HTTPPool) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body := r.Body
+	if p.opts.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, p.opts.MaxBodyBytes)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// 批量获取：请求体是序列化的pb.MultiRequest，响应体是序列化的pb.MultiResponse。
+func (p *HTTPPool) ServeMulti(w http.ResponseWriter, r *http.Request, groupName string) {
+	group := p.group(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+	req := &pb.MultiRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var res *pb.MultiResponse
+	if r.Header.Get(peerForwardHeader) != "" {
+		res = group.serveMulti(r.Context(), req.GetKeys())
+	} else {
+		views, errs := group.GetMultiContext(r.Context(), req.GetKeys())
+		res = multiResponse(req.GetKeys(), views, errs)
+	}
+	body, err := proto.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
 func (p *HTTPPool) ServeInternalInfo(w http.ResponseWriter, groupName string) {
-	group := GetGroup(groupName)
+	group := p.group(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
 	info := group.GetCacheInfo()
+	stats := group.GetStats()
 	response := &pb.InfoResponse{
 		KeysNum:          info.KeysNum,
 		CurrentUsedBytes: info.CurrentCacheBytes,
 		MaxUsedBytes:     info.MaxCacheBytes,
+		Peers:            p.peerStates(),
+		Rebalance:        p.rebalanceState(),
+		DiskLiveBytes:    info.DiskLiveBytes,
+		DiskDeadBytes:    info.DiskDeadBytes,
+		DiskKeysNum:      info.DiskKeysNum,
+		Stats: &pb.GroupStats{
+			Gets:            stats.Gets.Get(),
+			Hits:            stats.Hits.Get(),
+			Misses:          stats.Misses.Get(),
+			PeerLoads:       stats.PeerLoads.Get(),
+			PeerErrors:      stats.PeerErrors.Get(),
+			LocalLoads:      stats.LocalLoads.Get(),
+			LocalLoadErrors: stats.LocalLoadErrors.Get(),
+			DedupedLoads:    stats.DedupedLoads.Get(),
+			Evictions:       stats.Evictions.Get(),
+			DiskHits:        stats.DiskHits.Get(),
+		},
 	}
 	body, err := proto.Marshal(response)
 	if err != nil {
@@ -148,7 +412,7 @@ func (p *HTTPPool) ServeInternalInfo(w http.ResponseWriter, groupName string) {
 }
 
 func (p *HTTPPool) ServeInternalBackup(w http.ResponseWriter, groupName string) {
-	group := GetGroup(groupName)
+	group := p.group(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
@@ -163,24 +427,145 @@ func (p *HTTPPool) ServeInternalBackup(w http.ResponseWriter, groupName string)
 
 // 客户端部分：
 
+/*
+Set 传入所有节点（包括本节点）的地址，替换原有的节点集合，全部节点的权重均为1。
+仍在列表中的节点保留原有的httpGetter、熔断器和健康状态，只有增减的节点会改变一致性哈希。
+*/
 func (p *HTTPPool) Set(peerIPs ...string) {
+	weights := make(map[string]int, len(peerIPs))
+	for _, peer := range peerIPs {
+		weights[peer] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+/*
+SetWeighted 与 Set 相同，但为每个节点（包括本节点）指定权重，节点负责的key的数量大致与权重成正比。
+例如同时有4GB和32GB内存的机器时，可以将权重设为4和32。
+*/
+func (p *HTTPPool) SetWeighted(weights map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var removed []string
+	for peer := range p.health {
+		if _, ok := weights[peer]; !ok {
+			removed = append(removed, peer)
+		}
+	}
+	p.removePeers(removed...)
+	for peer, weight := range weights {
+		p.addPeer(peer, weight)
+	}
+	p.ringChanged()
+}
+
+// AddPeers 以权重1加入新节点，已存在的节点会被忽略。其他节点的httpGetter和一致性哈希中的位置不变。
+func (p *HTTPPool) AddPeers(peerIPs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, peer := range peerIPs {
+		if _, ok := p.health[peer]; !ok {
+			p.addPeer(peer, 1)
+		}
+	}
+	p.ringChanged()
+}
+
+// RemovePeers 移除节点，不存在的节点会被忽略。只有被移除节点负责的key会转移到其他节点。
+func (p *HTTPPool) RemovePeers(peerIPs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removePeers(peerIPs...)
+	p.ringChanged()
+}
+
+// 加入节点或修改已有节点的权重。调用者需持有p.mu。
+func (p *HTTPPool) addPeer(peer string, weight int) {
+	if p.peers == nil {
+		p.peers = p.newPlacement() // 新建一个一致性哈希字典
+		p.httpGetters = make(map[string]*httpGetter)
+		p.health = make(map[string]*peerHealth)
+		p.weights = make(map[string]int)
+	}
+	h, ok := p.health[peer]
+	if !ok {
+		p.httpGetters[peer] = p.newGetter(peer) // 一个IP地址，指向一个数据获得器。
+		h = &peerHealth{up: true}
+		p.health[peer] = h
+	}
+	p.weights[peer] = weight
+	if h.up {
+		p.peers.AddWeighted(peer, weight)
+	}
+}
+
+// 调用者需持有p.mu。被移除节点的请求耗时指标一并删除，否则节点不断变化时指标会无限增长。
+func (p *HTTPPool) removePeers(peerIPs ...string) {
+	for _, peer := range peerIPs {
+		if getter, ok := p.httpGetters[peer]; ok {
+			peerRequestDuration.DeleteMatching("peer", getter.baseURL)
+		}
+		delete(p.httpGetters, peer)
+		delete(p.health, peer)
+		delete(p.weights, peer)
+	}
+	if p.peers != nil {
+		p.peers.Remove(peerIPs...)
+	}
+}
+
+// 按配置新建选择节点的算法，算法已在NewHTTPPoolOpts中检查。
+func (p *HTTPPool) newPlacement() consistenthash.Placement {
+	if p.opts.Placement == consistenthash.BoundedLoads {
+		return consistenthash.NewBounded(p.opts.Replicas, p.opts.LoadBound, nil)
+	}
+	placement, _ := consistenthash.NewPlacement(p.opts.Placement, p.opts.Replicas)
+	return placement
+}
+
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	return &httpGetter{
+		baseURL: peer + p.basePath,
+		client:  p.opts.Client,
+		timeout: p.opts.Timeout,
+		retries: p.opts.Retries,
+		backoff: p.opts.RetryBackoff,
+		breaker: newCircuitBreaker(p.opts.BreakerThreshold, p.opts.BreakerCooldown),
+	}
+}
+
+// 各远程节点熔断器的状态，按地址排序。
+func (p *HTTPPool) peerStates() []*pb.PeerState {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil) // 新建一个一致性哈希字典。
-	p.peers.Add(peerIPs...)
-	p.httpGetters = make(map[string]*httpGetter, len(peerIPs))
-	for _, peer := range peerIPs { // peers是所有节点（包括本节点）的IP地址的集合
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath} // 一个IP地址，指向一个数据获得器。
+	states := make([]*pb.PeerState, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer == p.self {
+			continue
+		}
+		state, failures := getter.breaker.snapshot()
+		states = append(states, &pb.PeerState{
+			Peer:                peer,
+			BreakerState:        state.String(),
+			ConsecutiveFailures: int64(failures),
+			Up:                  p.health[peer].up,
+			Inflight:            getter.inflight.Get(),
+		})
 	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Peer < states[j].Peer })
+	return states
 }
 
 /*
 先通过p.peers的一致性哈希获得键值key对应的节点的IP地址，然后返回该IP地址对应的数据获得器httpGetters。
 peer是按一致性哈希字典得到的IP地址，如"https://example.net:8000"
+使用有界负载的一致性哈希时，先报告各节点当前的负载：远程节点为本节点发往它、尚未完成的请求数，
+本节点为正在处理的来自其他节点的请求数。负载超过上限的节点会被跳过，key由哈希环上的下一个节点负责。
 */
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.reportLoads()
 	if peer := p.peers.Get(key); peer != "" && peer != p.self {
 		p.Log("Pick peer %s", peer)
 		return p.httpGetters[peer], true // 如果peer不是空且不是本节点，则返回peer对应的【数据获得器】。
@@ -188,37 +573,309 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	return nil, false
 }
 
+// PickOwner 返回key的归属节点的【数据获得器】，与节点的负载无关（见ringNodes），实现OwnerPicker。
+func (p *HTTPPool) PickOwner(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nodes := p.ringNodes(key, 1); len(nodes) > 0 && nodes[0] != p.self {
+		return p.httpGetters[nodes[0]], true
+	}
+	return nil, false
+}
+
+/*
+PickReplicas 返回key的ReplicaCount个副本中的远程节点，以及本节点是否也是副本，实现ReplicaPicker。
+副本由ringNodes决定，与节点的负载无关。
+*/
+func (p *HTTPPool) PickReplicas(key string) (peers []PeerGetter, self bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, peer := range p.ringNodes(key, p.opts.ReplicaCount) {
+		if peer == p.self {
+			self = true
+		} else {
+			peers = append(peers, p.httpGetters[peer])
+		}
+	}
+	return peers, self
+}
+
+/*
+PickPeers 按一致性哈希上的顺序返回key的主节点和ReplicaCount-1个副本节点的【数据获得器】，实现ReplicaPicker。
+遇到本节点时截止，由本节点直接导入。被健康检查移出一致性哈希的节点不在其中。
+*/
+func (p *HTTPPool) PickPeers(key string) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reportLoads()
+	var getters []PeerGetter
+	for _, peer := range p.peers.GetN(key, p.opts.ReplicaCount) {
+		if peer == p.self {
+			break
+		}
+		getters = append(getters, p.httpGetters[peer])
+	}
+	return getters
+}
+
+/*
+返回key的前n个节点，与节点的负载无关：使用有界负载时按底层的哈希环计算。
+副本和key的归属必须在各节点上一致且稳定，不能随各节点看到的负载变化。调用者需持有p.mu。
+*/
+func (p *HTTPPool) ringNodes(key string, n int) []string {
+	if bounded, ok := p.peers.(*consistenthash.Bounded); ok {
+		return bounded.Map.GetN(key, n)
+	}
+	return p.peers.GetN(key, n)
+}
+
+// 使用有界负载的一致性哈希时，报告各节点当前的负载。调用者需持有p.mu。
+func (p *HTTPPool) reportLoads() {
+	bounded, ok := p.peers.(*consistenthash.Bounded)
+	if !ok {
+		return
+	}
+	for peer, getter := range p.httpGetters {
+		if peer == p.self {
+			bounded.SetLoad(peer, p.inflight.Get())
+		} else {
+			bounded.SetLoad(peer, getter.inflight.Get())
+		}
+	}
+}
+
+// 返回全部健康的远程节点的【数据获得器】，不包括本节点。
+func (p *HTTPPool) GetAll() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	getters := make([]PeerGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self && p.health[peer].up {
+			getters = append(getters, getter)
+		}
+	}
+	return getters
+}
+
 var _ PeerPicker = (*HTTPPool)(nil) // 检查HTTPPool是否实现了【数据获得器的选择器】PeerPicker接口
+var _ ReplicaPicker = (*HTTPPool)(nil)
+var _ OwnerPicker = (*HTTPPool)(nil)
+var _ ReplicaGetter = (*httpGetter)(nil)
+
+// 向各个远程节点发出请求的耗时（秒），标签为节点地址和操作类型。
+var peerRequestDuration = metrics.NewHistogramVec(nil, "peer", "op")
 
 // 创建 httpGetter，实现 PeerGetter 接口。——基于HTTP的【数据获得器】。
 type httpGetter struct {
-	baseURL string // 表示将要访问的远程节点的地址，例如 http://example.com/_mycache/。
+	baseURL string          // 表示将要访问的远程节点的地址，例如 http://example.com/_mycache/。
+	client  *http.Client    // 为nil时使用http.DefaultClient
+	timeout time.Duration   // 每次请求的超时时间，小于等于0表示不设超时
+	retries int             // 最大重试次数，小于等于0表示不重试
+	backoff time.Duration   // 第一次重试前等待时间的上限
+	breaker *circuitBreaker // 为nil时不使用熔断器
+
+	inflight AtomicInt // 已发出、尚未完成的请求数
 }
 
-func (h *httpGetter) Get(in *pb.Request, out *pb.KVResponse) error {
-	u := fmt.Sprintf(
+// 远程节点上group中key对应的URL。
+func (h *httpGetter) url(group, key string) string {
+	return fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 		// func QueryEscape(s string) string ：该函数对s进行转码使之可以安全的用在URL查询里。
 		// url.QueryEscape("http://images.com /cat.png")的结果是"http%3A%2F%2Fimages.com+%2Fcat.png"
 	)
-	res, err := http.Get(u)
+}
+
+/*
+发出请求并返回状态码为200的响应体。newRequest在每次尝试时被调用，以便重新生成请求体。
+熔断器断开时直接返回ErrCircuitOpen；ctx被取消时不再重试，也不计入失败。
+*/
+func (h *httpGetter) do(ctx context.Context, op string, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	if !h.breaker.allow() {
+		return nil, fmt.Errorf("peer %s: %w", h.baseURL, ErrCircuitOpen)
+	}
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	defer peerRequestDuration.With(h.baseURL, op).ObserveSince(time.Now())
+	for attempt := 0; ; attempt++ {
+		body, retryable, err := h.try(ctx, newRequest)
+		if err == nil || !retryable {
+			h.breaker.success()
+			return body, err
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if attempt >= h.retries {
+			h.breaker.failure()
+			return nil, err
+		}
+		select {
+		case <-time.After(h.backoffDuration(attempt)):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// 发出一次请求。retryable为true表示节点可能不可用（网络错误、超时或网关错误），可以重试。
+func (h *httpGetter) try(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (body []byte, retryable bool, err error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	req, err := newRequest(ctx)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("peer %s: %w", h.baseURL, err)
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+	body, err = io.ReadAll(res.Body) // ReadAll() 是一次读取所有数据
+	if err != nil {
+		return nil, true, fmt.Errorf("peer %s: reading response body: %w", h.baseURL, err)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return body, false, nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		retryable = true
+	}
+	return nil, retryable, fmt.Errorf("peer %s: server returned: %v: %s", h.baseURL, res.Status, strings.TrimSpace(string(body)))
+}
+
+// 第attempt+1次重试前的等待时间：在[0, backoff*2^attempt)中随机选取。
+func (h *httpGetter) backoffDuration(attempt int) time.Duration {
+	d := h.backoff << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (h *httpGetter) Get(in *pb.Request, out *pb.KVResponse) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 以带有peerForwardHeader的 GET 请求获取远程节点负责的key，由远程节点直接从缓存或本地导入。
+func (h *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.KVResponse) error {
+	body, err := h.do(ctx, "get", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url(in.GetGroup(), in.GetKey()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(peerForwardHeader, "1")
+		return req, nil
+	})
+	if err != nil {
+		return err
 	}
 	// 需要事先协商好：传来的数据必须是序列化的pb.KVResponse格式的数据。
-	bytes, err := io.ReadAll(res.Body) // ReadAll() 是一次读取所有数据
-	if err = proto.Unmarshal(bytes, out); err != nil {
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// Set 以带有peerForwardHeader的 PUT 请求将值写入远程节点，请求体就是值本身，由远程节点直接写入本地。
+func (h *httpGetter) Set(in *pb.SetRequest) error {
+	_, err := h.do(context.Background(), "set", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.url(in.GetGroup(), in.GetKey()), bytes.NewReader(in.GetValue()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(peerForwardHeader, "1")
+		return req, nil
+	})
+	return err
+}
+
+// Delete 以 DELETE 请求删除远程节点本地的数据，请求头peerDeleteHeader告知对方不要再广播。
+func (h *httpGetter) Delete(in *pb.Request) error {
+	_, err := h.do(context.Background(), "delete", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, h.url(in.GetGroup(), in.GetKey()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(peerDeleteHeader, "1")
+		return req, nil
+	})
+	return err
+}
+
+// GetReplica 以带有peerReplicaHeader的 GET 请求读取远程节点本地保存的副本及其版本。
+func (h *httpGetter) GetReplica(ctx context.Context, in *pb.Request, out *pb.KVResponse) error {
+	body, err := h.do(ctx, "get_replica", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url(in.GetGroup(), in.GetKey()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(peerReplicaHeader, "1")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// SetReplica 以带有peerReplicaHeader的 PUT 请求写入远程节点本地的副本，版本放在请求头peerTimestampHeader中。
+func (h *httpGetter) SetReplica(ctx context.Context, in *pb.SetRequest) error {
+	_, err := h.do(ctx, "set_replica", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.url(in.GetGroup(), in.GetKey()), bytes.NewReader(in.GetValue()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(peerReplicaHeader, "1")
+		req.Header.Set(peerTimestampHeader, strconv.FormatUint(in.GetTimestamp(), 10))
+		if in.GetExpire() > 0 {
+			req.Header.Set(peerExpireHeader, strconv.FormatInt(in.GetExpire(), 10))
+		}
+		return req, nil
+	})
+	return err
+}
+
+// GetMulti 以带有peerForwardHeader的 POST 请求批量获取远程节点负责的多个key。
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) error {
+	reqBody, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := h.baseURL + url.QueryEscape(in.GetGroup())
+	body, err := h.do(ctx, "get_multi", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(peerForwardHeader, "1")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 	return nil
 }
 
-var _ PeerGetter = (*httpGetter)(nil)
+var _ ContextPeerGetter = (*httpGetter)(nil)
+var _ PeerBatchGetter = (*httpGetter)(nil)
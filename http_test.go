@@ -0,0 +1,500 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mycache/consistenthash"
+	pb "mycache/mycachepb"
+)
+
+func TestHTTPSet(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "http-set"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("getter should not be called")
+	}))
+	pool := NewHTTPPool("http://self")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	if err := peer.Set(&pb.SetRequest{Group: g.name, Key: "Tom", Value: []byte("630")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("get Tom = %q, %v; want 630", v.String(), err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "no-such-group", Key: "Tom"}); err == nil {
+		t.Fatalf("set on unknown group should fail")
+	}
+
+	small := httptest.NewServer(NewHTTPPoolOpts("http://self", &HTTPPoolOptions{MaxBodyBytes: 8}))
+	defer small.Close()
+	req, _ := http.NewRequest("PUT", small.URL+defaultBasePath+g.name+"/big", strings.NewReader("0123456789"))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("put of an oversized value = %d, want 413", res.StatusCode)
+	}
+	if _, ok := g.mainCache.get("big"); ok {
+		t.Fatal("oversized value was stored")
+	}
+}
+
+func TestDeleteBroadcast(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "http-delete"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}))
+	var mu sync.Mutex
+	deletes := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && r.Header.Get(peerDeleteHeader) != "" {
+			mu.Lock()
+			deletes++
+			mu.Unlock()
+		}
+		NewHTTPPool("http://remote").ServeHTTP(w, r)
+	})
+	s1 := httptest.NewServer(handler)
+	defer s1.Close()
+	s2 := httptest.NewServer(handler)
+	defer s2.Close()
+	dead := httptest.NewServer(handler)
+	dead.Close()
+
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", s1.URL, s2.URL)
+	g.RegisterPeers(pool)
+	if err := g.Delete("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if deletes != 2 {
+		t.Fatalf("delete reached %d peers, want 2", deletes)
+	}
+
+	pool.Set("http://self", s1.URL, s2.URL, dead.URL)
+	err := g.Delete("Tom")
+	if err == nil || !strings.Contains(err.Error(), dead.URL) || !strings.Contains(err.Error(), "1 of 3 peers") {
+		t.Fatalf("delete with an unreachable peer returned %v", err)
+	}
+}
+
+func TestGetContextCancelsPeerAndLoader(t *testing.T) {
+	canceled := make(chan struct{})
+	newTestGroup(t, Conf{Name: "http-context"}, 2<<10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}))
+	server := httptest.NewServer(NewHTTPPool("http://remote"))
+	defer server.Close()
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := peer.GetContext(ctx, &pb.Request{Group: "http-context", Key: "Tom"}, &pb.KVResponse{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext returned %v, want deadline exceeded", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("remote loader was not canceled")
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "http-metrics"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}))
+	pool := NewHTTPPool("http://self")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	if err := peer.Get(&pb.Request{Group: g.name, Key: "a"}, &pb.KVResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	g.Get("a")
+
+	res, err := http.Get(server.URL + internalBasePath + "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	for _, want := range []string{
+		`# TYPE mycache_hit_ratio gauge`,
+		`mycache_gets_total{group="http-metrics"} 2`,
+		`mycache_hit_ratio{group="http-metrics"} 0.5`,
+		`mycache_cache_keys{group="http-metrics"} 1`,
+		`mycache_peer_request_duration_seconds_count{peer="` + peer.baseURL + `",op="get"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+// 被移除的节点的请求耗时指标不再输出，节点不断变化时指标不会无限增长。
+func TestServeMetricsRemovedPeer(t *testing.T) {
+	remote := newTestGroup(t, Conf{Name: "metrics-remote"}, 2<<10, &batchGetter{})
+	_, server := newRemoteNode(t, "http://remote", "metrics-local", remote)
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", server.URL)
+	pool.mu.Lock()
+	peer := pool.httpGetters[server.URL]
+	pool.mu.Unlock()
+	if err := peer.Get(&pb.Request{Group: "metrics-local", Key: "a"}, &pb.KVResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		pool.ServeMetrics(rec)
+		return rec.Body.String()
+	}
+	series := `mycache_peer_request_duration_seconds_count{peer="` + peer.baseURL + `",op="get"} 1`
+	if !strings.Contains(scrape(), series) {
+		t.Fatalf("metrics missing %q", series)
+	}
+	pool.RemovePeers(server.URL)
+	if body := scrape(); strings.Contains(body, `peer="`+peer.baseURL+`"`) {
+		t.Fatalf("metrics still contain the removed peer:\n%s", body)
+	}
+}
+
+func TestHTTPRetryAndCircuitBreaker(t *testing.T) {
+	newTestGroup(t, Conf{Name: "http-breaker"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}))
+	var mu sync.Mutex
+	requests, failures := 0, 0 // failures：接下来返回503的请求数
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		fail := failures > 0
+		if fail {
+			failures--
+		}
+		mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		NewHTTPPool("http://remote").ServeHTTP(w, r)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	setFailures := func(n int) {
+		mu.Lock()
+		requests, failures = 0, n
+		mu.Unlock()
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		Retries:          2,
+		RetryBackoff:     time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	pool.Set("http://self", server.URL)
+	peer := pool.httpGetters[server.URL]
+	req := &pb.Request{Group: "http-breaker", Key: "Tom"}
+
+	// 两次失败后第三次尝试成功。
+	setFailures(2)
+	if err := peer.Get(req, &pb.KVResponse{}); err != nil || count() != 3 {
+		t.Fatalf("get with 2 failures = %v after %d requests, want success after 3", err, count())
+	}
+
+	// 每次Get重试后仍然失败，连续失败2次后熔断器断开，不再发出请求。
+	setFailures(100)
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(req, &pb.KVResponse{}); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("get %d = %v, want a server error", i, err)
+		}
+	}
+	if err := peer.Get(req, &pb.KVResponse{}); !errors.Is(err, ErrCircuitOpen) || count() != 6 {
+		t.Fatalf("get with open breaker = %v after %d requests, want ErrCircuitOpen after 6", err, count())
+	}
+	if states := pool.peerStates(); len(states) != 1 || states[0].BreakerState != "open" {
+		t.Fatalf("peer states = %v, want one open breaker", states)
+	}
+
+	// 冷却时间过后，试探请求成功，熔断器闭合。
+	setFailures(0)
+	time.Sleep(60 * time.Millisecond)
+	if err := peer.Get(req, &pb.KVResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if states := pool.peerStates(); states[0].BreakerState != "closed" {
+		t.Fatalf("peer states = %v, want closed breaker", states)
+	}
+}
+
+func TestHTTPPeerTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Timeout: 20 * time.Millisecond, Retries: -1})
+	pool.Set(server.URL)
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatal("the only peer should be picked")
+	}
+	start := time.Now()
+	err := peer.Get(&pb.Request{Group: "g", Key: "Tom"}, &pb.KVResponse{})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("get = %v after %v, want a timeout", err, time.Since(start))
+	}
+}
+
+func TestHTTPPoolAddRemovePeers(t *testing.T) {
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", "http://a", "http://b")
+	getterA := pool.httpGetters["http://a"]
+	owners := make(map[string]string) // key -> 节点，""表示本节点
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = pool.peers.Get(key)
+	}
+
+	pool.AddPeers("http://c", "http://a")
+	if pool.httpGetters["http://a"] != getterA {
+		t.Fatal("adding an existing peer should keep its httpGetter")
+	}
+	for key, owner := range owners {
+		if got := pool.peers.Get(key); got != owner && got != "http://c" {
+			t.Fatalf("key %s moved from %s to %s, only moves to the new peer are expected", key, owner, got)
+		}
+	}
+
+	pool.RemovePeers("http://c", "http://b")
+	if got := pool.peers.Nodes(); len(got) != 2 || got[0] != "http://a" || got[1] != "http://self" {
+		t.Fatalf("nodes after remove = %v", got)
+	}
+	if len(pool.GetAll()) != 1 {
+		t.Fatalf("GetAll returned %d peers, want 1", len(pool.GetAll()))
+	}
+	for key, owner := range owners {
+		if owner != "http://b" && pool.peers.Get(key) != owner {
+			t.Fatalf("key %s moved from %s to %s, only keys of the removed peer should move", key, owner, pool.peers.Get(key))
+		}
+	}
+
+	pool.SetWeighted(map[string]int{"http://self": 1, "http://a": 4})
+	if pool.peers.Weight("http://a") != 4 || pool.httpGetters["http://a"] != getterA {
+		t.Fatalf("SetWeighted should reweight a in place, weight = %d", pool.peers.Weight("http://a"))
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	for _, alg := range []consistenthash.Algorithm{consistenthash.Rendezvous, consistenthash.Jump, consistenthash.Maglev} {
+		pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Placement: alg})
+		pool.Set("http://self", "http://a")
+		remote := 0
+		for i := 0; i < 1000; i++ {
+			if _, ok := pool.PickPeer(strconv.Itoa(i)); ok {
+				remote++
+			}
+		}
+		if remote < 400 || remote > 600 {
+			t.Errorf("%s: %d of 1000 keys picked the remote peer, want about half", alg, remote)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("unknown placement algorithm should panic")
+		}
+	}()
+	NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Placement: "unknown"})
+}
+
+func TestHTTPPoolBoundedLoads(t *testing.T) {
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Placement: consistenthash.BoundedLoads})
+	pool.Set("http://self", "http://a", "http://b")
+	var key string
+	for i := 0; key == ""; i++ {
+		if peer, ok := pool.PickPeer(strconv.Itoa(i)); ok && peer == pool.httpGetters["http://a"] {
+			key = strconv.Itoa(i)
+		}
+	}
+	// 模拟发往a的请求尚未完成，a的负载超过上限后，key应由其他节点负责。
+	pool.httpGetters["http://a"].inflight.Add(10)
+	if peer, ok := pool.PickPeer(key); ok && peer == pool.httpGetters["http://a"] {
+		t.Fatalf("overloaded peer a should be skipped for key %s", key)
+	}
+	if peer, ok := pool.PickOwner(key); !ok || peer != pool.httpGetters["http://a"] {
+		t.Fatalf("the owner of key %s should stay peer a regardless of its load", key)
+	}
+	pool.httpGetters["http://a"].inflight.Add(-10)
+	if peer, ok := pool.PickPeer(key); !ok || peer != pool.httpGetters["http://a"] {
+		t.Fatalf("key %s should return to peer a once its load drops", key)
+	}
+}
+
+// 其他节点转发来的请求由本节点直接处理：即使本节点按自己的PickPeer认为key属于其他节点，也不再转发。
+func TestHTTPForwardedGetServedLocally(t *testing.T) {
+	forwarded := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		http.Error(w, "should not be forwarded", http.StatusInternalServerError)
+	}))
+	defer other.Close()
+	remoteGetter := &batchGetter{}
+	remoteGroup := newTestGroup(t, Conf{Name: "forward-remote"}, 2<<10, remoteGetter)
+	remoteGroup.RegisterPeers(&prefixPicker{remote: &httpGetter{baseURL: other.URL + defaultBasePath}})
+	_, server := newRemoteNode(t, "http://remote", "forward-local", remoteGroup)
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	res := &pb.KVResponse{}
+	if err := peer.Get(&pb.Request{Group: "forward-local", Key: "r1"}, res); err != nil || string(res.Value) != "v-r1" {
+		t.Fatalf("forwarded get = %q, %v", res.Value, err)
+	}
+	multi := &pb.MultiResponse{}
+	if err := peer.GetMulti(context.Background(), &pb.MultiRequest{Group: "forward-local", Keys: []string{"r2", "r3"}}, multi); err != nil {
+		t.Fatal(err)
+	}
+	if len(multi.Results) != 2 || string(multi.Results[0].Value) != "v-r2" || string(multi.Results[1].Value) != "v-r3" {
+		t.Fatalf("forwarded get multi = %v", multi.Results)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "forward-local", Key: "r4", Value: []byte("put")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := remoteGroup.mainCache.get("r4"); !ok || v.String() != "put" {
+		t.Fatalf("forwarded set stored %q, %v; want put", v.String(), ok)
+	}
+	if forwarded != 0 {
+		t.Fatalf("%d requests were forwarded again", forwarded)
+	}
+}
+
+func TestHTTPReplicatedReads(t *testing.T) {
+	remote := newTestGroup(t, Conf{Name: "replica-remote"}, 2<<10, &batchGetter{})
+	_, alive := newRemoteNode(t, "http://alive", "replica-local", remote)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	g := newTestGroup(t, Conf{Name: "replica-local"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	}))
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{ReplicaCount: 2, Retries: -1})
+	pool.Set("http://self", dead.URL, alive.URL)
+	g.RegisterPeers(pool)
+
+	// replicaKey的主节点不可用、副本节点可用；localKey的副本是本节点。
+	var replicaKey, localKey string
+	deadGetter, aliveGetter := pool.httpGetters[dead.URL], pool.httpGetters[alive.URL]
+	for i := 0; replicaKey == "" || localKey == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		peers := pool.PickPeers(key)
+		if len(peers) == 2 && peers[0] == deadGetter && peers[1] == aliveGetter {
+			replicaKey = key
+		} else if len(peers) == 1 && peers[0] == deadGetter {
+			localKey = key
+		}
+	}
+
+	if v, err := g.Get(replicaKey); err != nil || v.String() != "v-"+replicaKey {
+		t.Fatalf("get %s = %q, %v; want the value from the replica", replicaKey, v.String(), err)
+	}
+	if stats := g.GetStats(); stats.PeerErrors != 1 || stats.PeerLoads != 1 || stats.LocalLoads != 0 {
+		t.Fatalf("stats = %+v, want one peer error and one peer load", stats)
+	}
+	if v, err := g.Get(localKey); err != nil || v.String() != "local-"+localKey {
+		t.Fatalf("get %s = %q, %v; want a local load", localKey, v.String(), err)
+	}
+
+	key := replicaKey + "-multi"
+	for peers := pool.PickPeers(key); len(peers) != 2 || peers[0] != deadGetter; peers = pool.PickPeers(key) {
+		key += "x"
+	}
+	values, errs := g.GetMulti([]string{key})
+	if errs[0] != nil || values[0].String() != "v-"+key {
+		t.Fatalf("get multi %s = %q, %v; want the value from the replica", key, values[0].String(), errs[0])
+	}
+}
+
+func TestHTTPReplica(t *testing.T) {
+	newTestGroup(t, Conf{Name: "http-replica"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("replica reads should not load " + key)
+	}))
+	server := httptest.NewServer(NewHTTPPool("http://remote"))
+	defer server.Close()
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	ctx := context.Background()
+
+	res := &pb.KVResponse{}
+	if err := peer.GetReplica(ctx, &pb.Request{Group: "http-replica", Key: "k"}, res); err != nil || res.Timestamp != 0 {
+		t.Fatalf("get missing replica = %v, timestamp %d; want an empty copy", err, res.Timestamp)
+	}
+	for _, req := range []*pb.SetRequest{
+		{Group: "http-replica", Key: "k", Value: []byte("new"), Timestamp: 200},
+		{Group: "http-replica", Key: "k", Value: []byte("old"), Timestamp: 100}, // 较旧的写入晚到，被忽略
+	} {
+		if err := peer.SetReplica(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res = &pb.KVResponse{}
+	if err := peer.GetReplica(ctx, &pb.Request{Group: "http-replica", Key: "k"}, res); err != nil || string(res.Value) != "new" || res.Timestamp != 200 {
+		t.Fatalf("get replica = %q, timestamp %d, %v; want new at 200", res.Value, res.Timestamp, err)
+	}
+	res = &pb.KVResponse{}
+	if err := peer.Get(&pb.Request{Group: "http-replica", Key: "k"}, res); err != nil || res.Timestamp != 200 {
+		t.Fatalf("get = %q, timestamp %d, %v; want the version of the copy", res.Value, res.Timestamp, err)
+	}
+
+	// 副本带着过期时间写入，本节点没有设置DefaultTTL也按它过期。
+	expire := time.Now().Add(50 * time.Millisecond).UnixMilli()
+	if err := peer.SetReplica(ctx, &pb.SetRequest{Group: "http-replica", Key: "ttl", Value: []byte("v"), Timestamp: 300, Expire: expire}); err != nil {
+		t.Fatal(err)
+	}
+	res = &pb.KVResponse{}
+	if err := peer.GetReplica(ctx, &pb.Request{Group: "http-replica", Key: "ttl"}, res); err != nil || res.Expire != expire {
+		t.Fatalf("get replica expire = %d, %v; want %d", res.Expire, err, expire)
+	}
+	time.Sleep(100 * time.Millisecond)
+	res = &pb.KVResponse{}
+	if err := peer.GetReplica(ctx, &pb.Request{Group: "http-replica", Key: "ttl"}, res); err != nil || res.Timestamp != 0 {
+		t.Fatalf("expired replica = %q, timestamp %d, %v; want an empty copy", res.Value, res.Timestamp, err)
+	}
+}
+
+// 使用有界负载时，副本由哈希环决定，不随节点的负载变化。
+func TestHTTPPickReplicasIgnoresLoad(t *testing.T) {
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Placement: consistenthash.BoundedLoads, ReplicaCount: 2})
+	pool.Set("http://self", "http://a", "http://b", "http://c")
+	before := make(map[string][]PeerGetter)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		before[key], _ = pool.PickReplicas(key)
+	}
+	pool.httpGetters["http://a"].inflight.Add(100)
+	defer pool.httpGetters["http://a"].inflight.Add(-100)
+	for key, want := range before {
+		if got, _ := pool.PickReplicas(key); !reflect.DeepEqual(got, want) {
+			t.Fatalf("replicas of %s changed with load: %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package lfu
+
+import (
+	"container/list"
+	"time"
+
+	"mycache/policy"
+)
+
+/*
+LFU(Least Frequently Used，最不经常使用)缓存器，无锁。
+每个访问次数对应一条双向链表，容量不足时淘汰访问次数最少的链表中最久未访问的记录。
+相比LRU，一次性扫描大量冷数据不会把访问次数多的热点数据挤出缓存。
+Get、Add、Remove的时间复杂度均为O(1)。
+*/
+type Cache struct {
+	maxBytes  int64                                // 允许使用的最大内存。0表示没有限制
+	nbytes    int64                                // 当前已使用的内存
+	nexpire   int                                  // 设置了过期时间的记录数量
+	cache     map[string]*list.Element             // 键是字符串，值是所在访问次数链表中对应节点的指针。
+	freqs     map[int]*list.List                   // 访问次数 -> 该次数下的记录链表，front 为最近访问
+	minFreq   int                                  // 当前最小的访问次数
+	OnEvicted func(key string, value policy.Value) // 某条记录被移除时的回调函数，可以为 nil。
+}
+
+type entry struct {
+	key    string
+	value  policy.Value
+	freq   int       // 访问次数
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(maxBytes int64, onEvicted func(string, policy.Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*list.Element),
+		freqs:     make(map[int]*list.List),
+		OnEvicted: onEvicted,
+	}
+}
+
+func (c *Cache) Get(key string) (policy.Value, bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.touch(ele)
+	return e.value, true
+}
+
+func (c *Cache) Add(key string, val policy.Value) bool {
+	return c.AddWithExpire(key, val, time.Time{})
+}
+
+func (c *Cache) AddWithExpire(key string, val policy.Value, expire time.Time) bool {
+	if c.maxBytes > 0 && int64(len(key))+val.Len() > c.maxBytes {
+		return false
+	}
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		c.nbytes += val.Len() - e.value.Len()
+		e.value = val
+		c.setExpire(e, expire)
+		c.touch(ele)
+	} else {
+		// 先腾出空间再插入，避免新记录因访问次数最少而被立即淘汰。
+		for c.maxBytes > 0 && c.nbytes+int64(len(key))+val.Len() > c.maxBytes && len(c.cache) > 0 {
+			c.RemoveOldest()
+		}
+		e := &entry{key: key, value: val, freq: 1}
+		c.setExpire(e, expire)
+		c.cache[key] = c.list(1).PushFront(e)
+		c.minFreq = 1
+		c.nbytes += int64(len(key)) + val.Len()
+	}
+	for c.maxBytes > 0 && c.nbytes > c.maxBytes {
+		c.RemoveOldest()
+	}
+	return true
+}
+
+// RemoveOldest 淘汰访问次数最少的记录，次数相同时淘汰最久未访问的。
+func (c *Cache) RemoveOldest() {
+	l, ok := c.freqs[c.minFreq]
+	if !ok {
+		// 主动删除可能使minFreq失效，此时重新计算。
+		c.minFreq = 0
+		for f := range c.freqs {
+			if c.minFreq == 0 || f < c.minFreq {
+				c.minFreq = f
+			}
+		}
+		if l, ok = c.freqs[c.minFreq]; !ok {
+			return
+		}
+	}
+	c.removeElement(l.Back())
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	if c.nexpire == 0 {
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for _, ele := range c.cache {
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Keys 返回全部未过期记录的key，不增加访问次数。
+func (c *Cache) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, len(c.cache))
+	for key, ele := range c.cache {
+		if !ele.Value.(*entry).expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *Cache) GetCurrentUsedBytes() int64 {
+	return c.nbytes
+}
+
+func (c *Cache) GetMaxUsedBytes() int64 {
+	return c.maxBytes
+}
+
+// 访问次数加一，将记录移动到下一个访问次数的链表。
+func (c *Cache) touch(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.unlink(ele)
+	if c.minFreq == e.freq && c.freqs[e.freq] == nil {
+		c.minFreq++
+	}
+	e.freq++
+	c.cache[e.key] = c.list(e.freq).PushFront(e)
+}
+
+func (c *Cache) list(freq int) *list.List {
+	l, ok := c.freqs[freq]
+	if !ok {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
+
+// 将节点从所在的访问次数链表中摘除，链表为空时一并删除。
+func (c *Cache) unlink(ele *list.Element) {
+	e := ele.Value.(*entry)
+	l := c.freqs[e.freq]
+	l.Remove(ele)
+	if l.Len() == 0 {
+		delete(c.freqs, e.freq)
+	}
+}
+
+func (c *Cache) setExpire(e *entry, expire time.Time) {
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if !expire.IsZero() {
+		c.nexpire++
+	}
+	e.expire = expire
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.unlink(ele)
+	delete(c.cache, e.key)
+	c.nbytes -= int64(len(e.key)) + e.value.Len()
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+var _ policy.Policy = (*Cache)(nil)
@@ -0,0 +1,40 @@
+package lfu
+
+import "testing"
+
+type String string
+
+func (d String) Len() int64 {
+	return int64(len(d))
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestEvictLeastFrequent(t *testing.T) {
+	// 每条记录占 2+2=4 字节，容量可以容纳3条。
+	lfu := New(int64(12), nil)
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.Add("k4", String("v4"))
+	if _, ok := lfu.Get("k2"); ok {
+		t.Fatalf("least frequently used k2 should be evicted")
+	}
+	for _, k := range []string{"k1", "k3", "k4"} {
+		if _, ok := lfu.Get(k); !ok {
+			t.Fatalf("%s should still be cached", k)
+		}
+	}
+}
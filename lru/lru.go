@@ -2,6 +2,9 @@ package lru
 
 import (
 	"container/list"
+	"time"
+
+	"mycache/policy"
 )
 
 // 链表一个很大的优点：插入快，删除快。而数组的有优点就是遍历快，索引快。
@@ -12,6 +15,7 @@ import (
 type Cache struct {
 	maxBytes  int64                                   // 允许使用的最大内存。0表示没有限制
 	nbytes    int64                                   // 当前已使用的内存
+	nexpire   int                                     // 设置了过期时间的记录数量，为0时后台清理可以直接跳过
 	ll        *list.List                              // 用于储存数据的双向链表
 	cache     map[string]*list.Element                // 键是字符串，值是双向链表中对应节点的指针。
 	OnEvicted func(key string, value ComputableValue) // 某条记录被移除时的回调函数，可以为 nil。
@@ -21,13 +25,16 @@ type Cache struct {
 type entry struct {
 	key         string
 	insideValue ComputableValue
+	expire      time.Time // 过期时间，零值表示永不过期
 }
 
-// 可计算大小的值
-type ComputableValue interface {
-	Len() int64
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
 
+// 可计算大小的值
+type ComputableValue = policy.Value
+
 func New(maxBytes int64, onEvicted func(string, ComputableValue)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
@@ -37,10 +44,15 @@ func New(maxBytes int64, onEvicted func(string, ComputableValue)) *Cache {
 	}
 }
 
+// Get 返回key对应的值。记录已过期时将其移除（惰性删除），并视为未命中。
 func (c *Cache) Get(key string) (temp ComputableValue, ok bool) {
 	if val, ok := c.cache[key]; ok { // val是*list.Element
-		c.ll.MoveToFront(val)     // 约定 front 为队尾
 		ele := val.Value.(*entry) // list.Element的值（Value）是接口，需要转换成*entry
+		if ele.expired(time.Now()) {
+			c.removeElement(val)
+			return nil, false
+		}
+		c.ll.MoveToFront(val) // 约定 front 为队尾
 		return ele.insideValue, true
 	}
 	return nil, false
@@ -49,14 +61,38 @@ func (c *Cache) Get(key string) (temp ComputableValue, ok bool) {
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		p := ele.Value.(*entry)
-		delete(c.cache, p.key)
-		c.nbytes -= int64(len(p.key)) + p.insideValue.Len()
-		if c.OnEvicted != nil {
-			c.OnEvicted(p.key, p.insideValue)
+		c.removeElement(ele)
+	}
+}
+
+// RemoveExpired 移除全部已过期的记录，返回移除的数量。供后台定期清理使用。
+func (c *Cache) RemoveExpired() int {
+	if c.nexpire == 0 {
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			removed++
+		}
+		ele = prev
+	}
+	return removed
+}
+
+// Keys 返回全部未过期记录的key，不改变记录在链表中的位置。
+func (c *Cache) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		if e := ele.Value.(*entry); !e.expired(now) {
+			keys = append(keys, e.key)
 		}
 	}
+	return keys
 }
 
 func (c *Cache) GetCurrentUsedBytes() int64 {
@@ -72,17 +108,25 @@ func (c *Cache) Len() int {
 }
 
 func (c *Cache) Add(key string, val ComputableValue) bool {
+	return c.AddWithExpire(key, val, time.Time{})
+}
+
+// AddWithExpire 插入一条在expire时刻过期的记录，expire为零值表示永不过期。
+func (c *Cache) AddWithExpire(key string, val ComputableValue, expire time.Time) bool {
 	// 插入成功则返回true，否则false
 	if c.maxBytes > 0 && int64(len(key))+val.Len() > c.maxBytes {
 		return false
 	}
 	if value0, ok := c.cache[key]; ok {
-		c.ll.PushFront(value0)
+		c.ll.MoveToFront(value0)
 		temp := value0.Value.(*entry)
-		c.nbytes += temp.insideValue.Len() - val.Len()
+		c.nbytes += val.Len() - temp.insideValue.Len()
 		temp.insideValue = val
+		c.setExpire(temp, expire)
 	} else {
-		ele := c.ll.PushFront(&entry{key, val})
+		temp := &entry{key: key, insideValue: val}
+		c.setExpire(temp, expire)
+		ele := c.ll.PushFront(temp)
 		c.cache[key] = ele
 		c.nbytes += int64(len(key)) + val.Len()
 	}
@@ -97,12 +141,31 @@ func (c *Cache) Remove(key string) {
 		return
 	}
 	if ele, ok := c.cache[key]; ok {
-		p := ele.Value.(*entry)
-		delete(c.cache, p.key)
-		c.ll.Remove(ele)
-		c.nbytes -= int64(len(p.key)) + p.insideValue.Len()
-		if c.OnEvicted != nil {
-			c.OnEvicted(p.key, p.insideValue)
-		}
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) setExpire(e *entry, expire time.Time) {
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if !expire.IsZero() {
+		c.nexpire++
+	}
+	e.expire = expire
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	p := ele.Value.(*entry)
+	delete(c.cache, p.key)
+	c.nbytes -= int64(len(p.key)) + p.insideValue.Len()
+	if !p.expire.IsZero() {
+		c.nexpire--
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(p.key, p.insideValue)
 	}
 }
+
+var _ policy.Policy = (*Cache)(nil)
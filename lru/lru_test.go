@@ -1,6 +1,9 @@
 package lru
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 type String string
 
@@ -17,4 +20,23 @@ func TestGet(t *testing.T) {
 	if _, ok := lru.Get("key2"); ok {
 		t.Fatalf("cache miss key2 failed")
 	}
-}
\ No newline at end of file
+}
+
+func TestExpire(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	lru.AddWithExpire("key3", String("90"), time.Now().Add(-time.Second))
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("expired key1 should miss")
+	}
+	if n := lru.RemoveExpired(); n != 1 {
+		t.Fatalf("RemoveExpired removed %d entries, want 1", n)
+	}
+	if v, ok := lru.Get("key2"); !ok || string(v.(String)) != "5678" {
+		t.Fatalf("cache hit key2=5678 failed")
+	}
+	if lru.Len() != 1 || lru.GetCurrentUsedBytes() != int64(len("key2")+len("5678")) {
+		t.Fatalf("expired entries should release bytes, got len=%d bytes=%d", lru.Len(), lru.GetCurrentUsedBytes())
+	}
+}
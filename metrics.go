@@ -0,0 +1,159 @@
+package mycache
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+
+	"mycache/metrics"
+)
+
+// 按Prometheus文本格式输出的Group指标：名称、说明、类型，以及从Group中取值的函数。
+var groupMetrics = []struct {
+	name, help, typ string
+	value           func(info CacheInfo, stats *Stats) float64
+}{
+	{"mycache_cache_bytes", "Bytes currently used by the group's main cache.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.CurrentCacheBytes) }},
+	{"mycache_cache_max_bytes", "Capacity of the group's main cache in bytes, 0 means unlimited.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.MaxCacheBytes) }},
+	{"mycache_cache_keys", "Number of keys in the group's main cache.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.KeysNum) }},
+	{"mycache_disk_keys", "Number of keys evicted from memory and kept only in the group's disk tier.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.DiskKeysNum) }},
+	{"mycache_persistence_live_bytes", "Bytes of live records in the group's persistence segments.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.DiskLiveBytes) }},
+	{"mycache_persistence_dead_bytes", "Bytes of overwritten or deleted records waiting for compaction.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 { return float64(info.DiskDeadBytes) }},
+	{"mycache_gets_total", "Get requests, including each key of GetMulti.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.Gets.Get()) }},
+	{"mycache_hits_total", "Get requests served from the main cache.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.Hits.Get()) }},
+	{"mycache_misses_total", "Get requests not found in the main cache.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.Misses.Get()) }},
+	{"mycache_hit_ratio", "Hits divided by gets since the group was created.", "gauge",
+		func(info CacheInfo, stats *Stats) float64 {
+			if gets := stats.Gets.Get(); gets > 0 {
+				return float64(stats.Hits.Get()) / float64(gets)
+			}
+			return 0
+		}},
+	{"mycache_peer_loads_total", "Values loaded from remote peers.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.PeerLoads.Get()) }},
+	{"mycache_peer_errors_total", "Failed loads from remote peers.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.PeerErrors.Get()) }},
+	{"mycache_local_loads_total", "Values loaded by the local getter.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.LocalLoads.Get()) }},
+	{"mycache_local_load_errors_total", "Failed loads by the local getter.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.LocalLoadErrors.Get()) }},
+	{"mycache_deduped_loads_total", "Loads that shared the result of a concurrent load of the same key.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.DedupedLoads.Get()) }},
+	{"mycache_evictions_total", "Entries evicted from the main cache because of capacity or expiry.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.Evictions.Get()) }},
+	{"mycache_disk_hits_total", "Get requests missed in memory and served from the disk tier, also counted as hits.", "counter",
+		func(info CacheInfo, stats *Stats) float64 { return float64(stats.DiskHits.Get()) }},
+}
+
+// 按名称排序返回全部Group。
+func allGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	ans := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		ans = append(ans, g)
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i].name < ans[j].name })
+	return ans
+}
+
+/*
+ServeMetrics 以Prometheus文本格式输出本节点的全部指标：
+各Group的缓存容量、键数量、持久化数据的有效和无效字节数、命中率和淘汰数量等，远程节点的健康状态和请求耗时，以及持久化写入和合并的耗时。
+*/
+func (p *HTTPPool) ServeMetrics(w http.ResponseWriter) {
+	var buf bytes.Buffer
+	gs := allGroups()
+	infos := make([]CacheInfo, len(gs))
+	stats := make([]Stats, len(gs))
+	for i, g := range gs {
+		infos[i] = g.GetCacheInfo()
+		stats[i] = g.GetStats()
+	}
+	for _, m := range groupMetrics {
+		metrics.WriteHeader(&buf, m.name, m.help, m.typ)
+		for i, g := range gs {
+			metrics.WriteSample(&buf, m.name, metrics.Labels("group", g.name), m.value(infos[i], &stats[i]))
+		}
+	}
+
+	p.writePeerHealth(&buf)
+	metrics.WriteHeader(&buf, "mycache_peer_request_duration_seconds", "Latency of requests sent to remote peers.", "histogram")
+	peerRequestDuration.Write(&buf, "mycache_peer_request_duration_seconds")
+
+	p.writeRebalance(&buf)
+
+	metrics.WriteHeader(&buf, "mycache_persistence_write_duration_seconds", "Latency of persistence Put and Delete.", "histogram")
+	for _, g := range gs {
+		if ws := g.mainCache.writeSequence; ws != nil {
+			ws.WriteDuration().Write(&buf, "mycache_persistence_write_duration_seconds", metrics.Labels("group", g.name))
+		}
+	}
+	metrics.WriteHeader(&buf, "mycache_persistence_merge_duration_seconds", "Latency of persistence Merge and background compaction.", "histogram")
+	for _, g := range gs {
+		if ws := g.mainCache.writeSequence; ws != nil {
+			ws.MergeDuration().Write(&buf, "mycache_persistence_merge_duration_seconds", metrics.Labels("group", g.name))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// 输出各远程节点的健康状态：是否在一致性哈希中、状态切换的次数，以及发往该节点尚未完成的请求数。
+func (p *HTTPPool) writePeerHealth(buf *bytes.Buffer) {
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.health))
+	up := make(map[string]bool, len(p.health))
+	transitions := make(map[string]int64, len(p.health))
+	inflight := make(map[string]int64, len(p.health))
+	for peer, h := range p.health {
+		if peer == p.self {
+			continue
+		}
+		peers = append(peers, peer)
+		up[peer], transitions[peer] = h.up, h.transitions
+		inflight[peer] = p.httpGetters[peer].inflight.Get()
+	}
+	p.mu.Unlock()
+	sort.Strings(peers)
+
+	metrics.WriteHeader(buf, "mycache_peer_up", "Whether the peer passed health checks and is in the hash ring.", "gauge")
+	for _, peer := range peers {
+		v := 0.0
+		if up[peer] {
+			v = 1
+		}
+		metrics.WriteSample(buf, "mycache_peer_up", metrics.Labels("peer", peer), v)
+	}
+	metrics.WriteHeader(buf, "mycache_peer_transitions_total", "Times the peer switched between up and down.", "counter")
+	for _, peer := range peers {
+		metrics.WriteSample(buf, "mycache_peer_transitions_total", metrics.Labels("peer", peer), float64(transitions[peer]))
+	}
+	metrics.WriteHeader(buf, "mycache_peer_inflight_requests", "Requests sent to the peer that have not completed.", "gauge")
+	for _, peer := range peers {
+		metrics.WriteSample(buf, "mycache_peer_inflight_requests", metrics.Labels("peer", peer), float64(inflight[peer]))
+	}
+}
+
+// 输出key迁移的状态，以及累计迁移成功和失败的key数。
+func (p *HTTPPool) writeRebalance(buf *bytes.Buffer) {
+	running := 0.0
+	if p.RebalanceProgress().Running {
+		running = 1
+	}
+	metrics.WriteHeader(buf, "mycache_rebalance_running", "Whether keys are being moved to their new owners after a ring change.", "gauge")
+	metrics.WriteSample(buf, "mycache_rebalance_running", "", running)
+	metrics.WriteHeader(buf, "mycache_rebalance_keys_total", "Keys moved to their new owners after ring changes.", "counter")
+	metrics.WriteSample(buf, "mycache_rebalance_keys_total", metrics.Labels("result", "moved"), float64(p.rebalance.moved.Get()))
+	metrics.WriteSample(buf, "mycache_rebalance_keys_total", metrics.Labels("result", "failed"), float64(p.rebalance.failed.Get()))
+}
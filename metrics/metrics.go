@@ -0,0 +1,224 @@
+/*
+metrics 实现了Prometheus文本格式（text exposition format 0.0.4）所需的最少功能：
+直方图（Histogram）、带标签的直方图（HistogramVec），以及按文本格式输出指标的函数。
+不依赖Prometheus的客户端库。
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets 是默认的直方图上界（单位：秒），覆盖1ms到10s，与Prometheus客户端库相同。
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/*
+Histogram 统计观测值的分布，可以并发使用。
+counts[i]是落在(buckets[i-1], buckets[i]]中的观测次数，最后一个元素对应+Inf。
+*/
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     uint64 // float64的位模式，通过CAS累加
+}
+
+// NewHistogram 以升序排列的上界buckets新建直方图，buckets为空时使用DefBuckets。
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe 记录一个观测值。
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) // 第一个大于等于v的上界
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sum, old, sum) {
+			return
+		}
+	}
+}
+
+// ObserveSince 记录从start到现在经过的秒数。
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count 返回观测次数。
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum 返回全部观测值之和。
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sum))
+}
+
+// Write 以文本格式输出直方图的_bucket、_sum和_count样本，labels是已格式化的标签（见Labels）。
+func (h *Histogram) Write(w io.Writer, name, labels string) {
+	var cum uint64
+	for i, le := range h.buckets {
+		cum += atomic.LoadUint64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(le)), cum)
+	}
+	cum += atomic.LoadUint64(&h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), cum)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.Sum()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, cum)
+}
+
+// HistogramVec 是一组标签名相同、标签值不同的直方图。
+type HistogramVec struct {
+	buckets []float64
+	names   []string // 标签名
+	mu      sync.RWMutex
+	hists   map[string]*Histogram // 已格式化的标签 -> 直方图
+	values  map[string][]string   // 已格式化的标签 -> 标签值，用于DeleteMatching
+}
+
+func NewHistogramVec(buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		buckets: buckets,
+		names:   labelNames,
+		hists:   make(map[string]*Histogram),
+		values:  make(map[string][]string),
+	}
+}
+
+// With 返回标签值为values的直方图，不存在时新建。values须与标签名一一对应。
+func (v *HistogramVec) With(values ...string) *Histogram {
+	if len(values) != len(v.names) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(v.names)))
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for i, name := range v.names {
+		pairs = append(pairs, name, values[i])
+	}
+	labels := Labels(pairs...)
+	v.mu.RLock()
+	h, ok := v.hists[labels]
+	v.mu.RUnlock()
+	if ok {
+		return h
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if h, ok = v.hists[labels]; !ok {
+		h = NewHistogram(v.buckets)
+		v.hists[labels] = h
+		v.values[labels] = append([]string(nil), values...)
+	}
+	return h
+}
+
+// DeleteMatching 删除标签name的值为value的全部直方图，返回删除的数量。用于移除已不存在的对象（如被移除的节点）的指标。
+func (v *HistogramVec) DeleteMatching(name, value string) int {
+	i := 0
+	for i < len(v.names) && v.names[i] != name {
+		i++
+	}
+	if i == len(v.names) {
+		return 0
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	deleted := 0
+	for labels, values := range v.values {
+		if values[i] == value {
+			delete(v.hists, labels)
+			delete(v.values, labels)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Write 按标签排序输出全部直方图。
+func (v *HistogramVec) Write(w io.Writer, name string) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.hists))
+	for labels := range v.hists {
+		keys = append(keys, labels)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, labels := range keys {
+		v.mu.RLock()
+		h, ok := v.hists[labels]
+		v.mu.RUnlock()
+		if ok { // 可能已被DeleteMatching删除
+			h.Write(w, name, labels)
+		}
+	}
+}
+
+// WriteHeader 输出指标的HELP和TYPE行，typ为counter、gauge或histogram。
+func WriteHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// WriteSample 输出一个样本，labels是已格式化的标签（见Labels）。
+func WriteSample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// Labels 将成对的标签名和标签值格式化为{name="value",...}，没有标签时返回空字符串。
+func Labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// 在已格式化的标签末尾追加一个标签。
+func withLabel(labels, name, value string) string {
+	pair := Labels(name, value)
+	if labels == "" {
+		return pair
+	}
+	return labels[:len(labels)-1] + "," + pair[1:]
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistogramWrite(t *testing.T) {
+	h := NewHistogram([]float64{1, 2})
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(1.5)
+	h.Observe(3)
+	var b strings.Builder
+	h.Write(&b, "x", Labels("peer", `a"b`))
+	want := `x_bucket{peer="a\"b",le="1"} 2
+x_bucket{peer="a\"b",le="2"} 3
+x_bucket{peer="a\"b",le="+Inf"} 4
+x_sum{peer="a\"b"} 6
+x_count{peer="a\"b"} 4
+`
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	v := NewHistogramVec(nil, "op")
+	v.With("get").Observe(0.001)
+	v.With("get").Observe(0.002)
+	v.With("set").Observe(0.001)
+	if n := v.With("get").Count(); n != 2 {
+		t.Fatalf("get count = %d, want 2", n)
+	}
+	var b strings.Builder
+	v.Write(&b, "y")
+	out := b.String()
+	if !strings.Contains(out, `y_count{op="get"} 2`) || !strings.Contains(out, `y_count{op="set"} 1`) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHistogramVecDeleteMatching(t *testing.T) {
+	v := NewHistogramVec(nil, "peer", "op")
+	v.With("a", "get").Observe(0.001)
+	v.With("a", "set").Observe(0.001)
+	v.With("b", "get").Observe(0.001)
+	if n := v.DeleteMatching("peer", "a"); n != 2 {
+		t.Fatalf("deleted %d histograms, want 2", n)
+	}
+	if n := v.DeleteMatching("missing", "a"); n != 0 {
+		t.Fatalf("deleted %d histograms by an unknown label, want 0", n)
+	}
+	var b strings.Builder
+	v.Write(&b, "z")
+	out := b.String()
+	if strings.Contains(out, `peer="a"`) || !strings.Contains(out, `z_count{peer="b",op="get"} 1`) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
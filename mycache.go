@@ -1,12 +1,14 @@
 package mycache
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"path/filepath"
 	"sync"
+	"time"
 
-	"mycache/lru"
 	pb "mycache/mycachepb"
 	"mycache/persistence"
 	"mycache/singleflight"
@@ -38,6 +40,59 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+/*
+TTLGetter 是可以为每个key单独指定过期时间的Getter。
+GetWithTTL 返回的ttl大于0时，覆盖Conf.DefaultTTL；等于0时使用Conf.DefaultTTL。
+*/
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// TTLGetterFunc 同时实现了Getter和TTLGetter接口，可以直接传给NewGroup。
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+// GetWithTTL implements TTLGetter interface function
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// Get implements Getter interface function
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+/*
+ContextGetter 是支持 context 的Getter，GetContext 应在ctx被取消或超时时尽快返回。
+Group.GetContext 的ctx会原样传给GetContext，从而把调用方的截止时间和取消传递到数据源。
+*/
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+/*
+ContextTTLGetter 同时支持 context 和为每个key单独指定过期时间，含义分别与ContextGetter、TTLGetter相同。
+getter需要同时使用两者时应实现该接口：只分别实现ContextGetter和TTLGetter时，getLocally使用TTLGetter，不传入ctx。
+*/
+type ContextTTLGetter interface {
+	GetWithTTLContext(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// ContextGetterFunc 同时实现了Getter和ContextGetter接口，可以直接传给NewGroup。
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext implements ContextGetter interface function
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+const defaultCleanupInterval = time.Minute
+
 type Conf struct {
 	Name               string
 	EnablePersistence  bool
@@ -45,6 +100,18 @@ type Conf struct {
 	LoadPersistentFile bool
 	FullPersistentFile string
 	IncrPersistentFile string
+	DefaultTTL         time.Duration           // 缓存的默认有效期，0表示永不过期
+	CleanupInterval    time.Duration           // 后台清理过期缓存的间隔，0表示使用默认值defaultCleanupInterval
+	EvictionPolicy     EvictionPolicy          // 缓存淘汰策略，为空时使用LRU
+	CacheShards        int                     // 缓存分片数，0表示根据容量使用默认值
+	Consistency        Consistency             // 副本读写的一致性级别，为空时使用ConsistencyOne，仅在PeerPicker启用了副本时有效
+	Fsync              persistence.FsyncPolicy // 持久化文件的fsync策略（always/interval/no），为空时由操作系统决定何时落盘
+	FsyncInterval      time.Duration           // Fsync为persistence.FsyncInterval时的间隔，0表示使用默认值persistence.DefaultFsyncInterval
+	SegmentSize        int64                   // 持久化数据段的大小上限，0表示使用默认值persistence.DefaultSegmentSize
+	CompactRatio       float64                 // 数据段中无效数据的比例达到该值时后台压缩，0表示使用默认值persistence.DefaultCompactRatio，小于0表示不按比例压缩
+	CompactBytes       int64                   // 无效数据合计达到该值时后台压缩，0表示使用默认值persistence.DefaultCompactBytes，小于0表示不按大小压缩
+	CompactInterval    time.Duration           // 后台检查是否需要压缩的间隔，0表示使用默认值persistence.DefaultCompactInterval，小于0表示不自动压缩
+	DiskBytes          int64                   // 磁盘层的容量，即被移出内存、只保存在持久化文件中的记录最多占用的字节数，0表示不启用磁盘层；需要开启EnablePersistence
 }
 
 /*
@@ -67,6 +134,10 @@ type Group struct {
 	loadPersistentFile bool                    // 是否在初始化时加载持久化文件
 	fullPersistentFile string                  // 初始化时加载的全量持久化文件，例如"./persistence/{name}/full.bin"
 	incrPersistentFile string                  // 初始化时加载的增量持久化文件
+	defaultTTL         time.Duration           // 缓存的默认有效期，0表示永不过期
+	consistency        Consistency             // 副本读写的一致性级别
+	stats              Stats                   // 统计信息，通过GetStats读取
+	closeOnce          sync.Once
 }
 
 func (g *Group) GetCacheInfo() CacheInfo {
@@ -85,33 +156,87 @@ func NewGroup(conf Conf, cacheBytes int64, getter Getter) *Group {
 	if len(conf.Name) == 0 {
 		panic("name error")
 	}
+	if reservedGroupNames[conf.Name] {
+		panic("group name " + conf.Name + " is reserved")
+	}
+	g := &Group{
+		name:               conf.Name,
+		getter:             getter,
+		loader:             &singleflight.GroupCall{},
+		fullPersistentFile: conf.FullPersistentFile,
+		defaultTTL:         conf.DefaultTTL,
+	}
+	mainCache, err := newCache(conf.EvictionPolicy, cacheBytes, conf.CacheShards, &g.stats.Evictions)
+	if err != nil {
+		panic(err)
+	}
+	if g.consistency, err = conf.Consistency.validate(); err != nil {
+		panic(err)
+	}
+	if conf.DiskBytes > 0 && (!conf.EnablePersistence || len(conf.PersistencePath) == 0) {
+		panic("DiskBytes requires EnablePersistence and PersistencePath")
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	var w *persistence.WriteSequence
-	var err error
 	if len(conf.PersistencePath) > 0 && (conf.EnablePersistence || len(conf.FullPersistentFile) > 0) {
 		group_persistence_path := filepath.Join(conf.PersistencePath, "/", conf.Name)
-		w, err = persistence.NewWriteSequence(group_persistence_path, conf.FullPersistentFile)
+		w, err = persistence.NewWriteSequenceOpts(group_persistence_path, conf.FullPersistentFile, &persistence.Options{
+			Fsync:           conf.Fsync,
+			FsyncInterval:   conf.FsyncInterval,
+			SegmentSize:     conf.SegmentSize,
+			CompactRatio:    conf.CompactRatio,
+			CompactBytes:    conf.CompactBytes,
+			CompactInterval: conf.CompactInterval,
+		})
 		if err != nil {
 			panic(err)
 		}
 	}
-	g := &Group{
-		name:               conf.Name,
-		getter:             getter,
-		mainCache:          cache{cacheBytes: cacheBytes, data: lru.New(cacheBytes, nil), writeSequence: w, enablePersistence: conf.EnablePersistence},
-		loader:             &singleflight.GroupCall{},
-		fullPersistentFile: conf.FullPersistentFile,
-	}
+	mainCache.writeSequence = w
+	mainCache.enablePersistence = conf.EnablePersistence
+	mainCache.ttl = conf.DefaultTTL
+	g.mainCache = mainCache
 	groups[conf.Name] = g
 	if len(conf.FullPersistentFile) > 0 {
-		g.mainCache.init()
+		g.mainCache.init(conf.DefaultTTL)
+	}
+	if conf.DiskBytes > 0 {
+		g.mainCache.enableDiskTier(conf.DiskBytes, conf.DefaultTTL, &g.stats.DiskHits)
 	}
+	interval := conf.CleanupInterval
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
+	g.mainCache.stop = make(chan struct{})
+	go g.mainCache.sweep(interval, g.mainCache.stop)
 	return g
 }
 
+/*
+Close 停止Group的后台清理，关闭持久化文件，并将Group从全局注册表中移除，之后GetGroup不再返回它。
+Close之后不应再使用该Group。重复调用Close是安全的，只有第一次调用会生效。
+*/
+func (g *Group) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.mainCache.stop)
+		mu.Lock()
+		if groups[g.name] == g {
+			delete(groups, g.name)
+		}
+		mu.Unlock()
+		if w := g.mainCache.writeSequence; w != nil {
+			err = w.Close()
+		}
+	})
+	return err
+}
+
 // 为Group设置HTTPPool。
 func (g *Group) RegisterPeers(peers PeerPicker) {
+	mu.Lock() // 后台的key迁移通过groupsUsing读取g.peers
+	defer mu.Unlock()
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
 	}
@@ -126,78 +251,298 @@ func GetGroup(name string) *Group {
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+/*
+GetContext 与 Get 相同，但ctx的截止时间和取消会传递到整个获取过程：
+等待single flight中其他请求的结果、向远程节点发出的请求，以及本地的getter（需实现ContextGetter或ContextTTLGetter）。
+*/
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, errors.New("key is required")
 	}
+	g.stats.Gets.Add(1)
 
 	if v, ok := g.mainCache.get(key); ok {
 		log.Println("[myCache] hit")
+		g.stats.Hits.Add(1)
 		return v, nil
 	}
+	g.stats.Misses.Add(1)
 	// 如果存在，即返回。
 	// 如果不存在，即导入（load）。
-	return g.load(key)
+	return g.load(ctx, key)
 }
 
-func (g *Group) Delete(key string) error {
+/*
+响应其他节点转发来的Get：查找mainCache，未命中时直接从本地导入，不再按PickPeer选择节点。
+发出请求的节点已经选择了本节点；使用有界负载时各节点看到的负载不同，再次选择可能把请求转发给其他节点，甚至转回来。
+*/
+func (g *Group) getForPeer(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, errors.New("key is required")
+	}
+	g.stats.Gets.Add(1)
+	if v, ok := g.mainCache.get(key); ok {
+		g.stats.Hits.Add(1)
+		return v, nil
+	}
+	g.stats.Misses.Add(1)
+	executed := false
+	viewi, err := g.loader.DoContext(ctx, key, func() (any, error) {
+		executed = true
+		return g.getLocally(ctx, key)
+	})
+	if !executed {
+		g.stats.DedupedLoads.Add(1)
+	}
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+/*
+Set 直接写入key对应的值，不经过getter。
+按一致性哈希选择key的归属节点（见pickOwner）：如果是其他节点，则将值发送给该节点，由它写入缓存和持久化文件；
+如果是本节点，则直接写入。写入失败时返回错误。
+PeerPicker启用了副本时，以当前时间为版本写入key的全部副本，按Conf.Consistency等待副本确认。
+*/
+func (g *Group) Set(key string, value []byte) error {
 	if key == "" {
 		return errors.New("key is required")
 	}
-	g.mainCache.delete(key)
+	if peers, self, ok := g.replicas(key); ok {
+		if err := g.writeReplicas(key, ByteView{data: cloneBytes(value), version: newVersion(), expire: expireAt(g.defaultTTL)}, peers, self); err != nil {
+			return err
+		}
+		if !self {
+			return g.mainCache.delete(key)
+		}
+		return nil
+	}
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			if err := g.setToPeer(peer, key, value); err != nil {
+				return err
+			}
+			// 本节点可能保存着旧值（例如之前从远程获取失败后由getLocally写入），一并删除。
+			return g.mainCache.delete(key)
+		}
+	}
+	return g.mainCache.add(key, ByteView{data: cloneBytes(value)}, g.defaultTTL)
+}
+
+/*
+响应其他节点转发来的Set：直接写入本节点的缓存和持久化文件，不再按PickPeer选择节点，原因同getForPeer。
+*/
+func (g *Group) setForPeer(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	return g.mainCache.add(key, ByteView{data: cloneBytes(value)}, g.defaultTTL)
+}
+
+/*
+Delete 在整个集群中删除key。
+先删除本节点的数据，再通知key对应的节点删除（它保存着持久化的数据），
+最后广播给其他全部节点，删除它们可能保存的副本。
+有节点删除失败时，返回的错误中会列出失败的节点。
+PeerPicker启用了副本时，只有key的副本删除失败且确认数不满足Conf.Consistency时才返回错误。
+*/
+func (g *Group) Delete(key string) error {
+	if peers, self, ok := g.replicas(key); ok {
+		return g.deleteReplicas(key, peers, self)
+	}
+	if err := g.deleteLocally(key); err != nil {
+		return err
+	}
+	if g.peers == nil {
+		return nil
+	}
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	owner, hasOwner := g.pickOwner(key)
+	var errs []error
+	if hasOwner {
+		if err := owner.Delete(req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	peers := g.peers.GetAll()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		if hasOwner && peer == owner {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := peer.Delete(req); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("delete %q: %d of %d peers failed: %w", key, len(errs), len(peers), errors.Join(errs...))
+	}
 	return nil
 }
 
+// 只删除本节点的数据（缓存和持久化文件），用于响应其他节点的删除请求。
+func (g *Group) deleteLocally(key string) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	return g.mainCache.delete(key)
+}
+
 func (g *Group) Backup() error {
 	return g.mainCache.backup()
 }
 
-// 使用 PickPeer() 方法选择节点，若非本机节点，则调用 getFromPeer() 从远程获取。
-// 若是本机节点或失败，则回退到 getLocally()。
-func (g *Group) load(key string) (value ByteView, err error) {
-	viewi, err := g.loader.Do(key, func() (any, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok { // 如果按一致性哈希该key应该由本节点储存则ok为false。
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
-				}
-				log.Println("[myCache] Failed to get from peer", err)
-			}
+// 使用 PickPeer() 方法选择节点，若非本机节点，则调用 getFromPeer() 从远程获取；失败时依次尝试副本节点。
+// 若是本机节点或全部失败，则回退到 getLocally()。
+// ctx被取消或超时时不再回退，直接返回ctx.Err()。
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	executed := false // fn只在本次调用中执行时为true，否则结果来自其他并发请求
+	viewi, err := g.loader.DoContext(ctx, key, func() (any, error) {
+		executed = true
+		if value, ok := g.readReplicas(ctx, key); ok {
+			return value, nil
 		}
-
-		return g.getLocally(key)
+		if value, ok := g.getFromPeers(ctx, key, nil); ok {
+			return value, nil
+		}
+		return g.getLocally(ctx, key)
 	})
+	if !executed {
+		g.stats.DedupedLoads.Add(1)
+	}
 	if err == nil {
 		return viewi.(ByteView), nil
 	}
 	return
 }
 
-// 从本地的回调函数获得key对应的值。
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+// 返回key的归属节点：g.peers实现了OwnerPicker时使用PickOwner，否则使用PickPeer。ok为false表示key由本节点负责。
+func (g *Group) pickOwner(key string) (PeerGetter, bool) {
+	if op, ok := g.peers.(OwnerPicker); ok {
+		return op.PickOwner(key)
+	}
+	return g.peers.PickPeer(key)
+}
+
+/*
+依次从key的主节点和副本节点获取，返回第一个成功的结果；ok为false表示应回退到本地导入。
+g.peers实现了ReplicaPicker时尝试PickPeers返回的全部节点，否则只尝试PickPeer返回的节点（如果按一致性哈希该key应该由本节点储存则ok为false）。
+skip是已经请求失败的节点，不再重复请求。
+*/
+func (g *Group) getFromPeers(ctx context.Context, key string, skip PeerGetter) (value ByteView, ok bool) {
+	if g.peers == nil {
+		return ByteView{}, false
+	}
+	var peers []PeerGetter
+	if rp, isReplica := g.peers.(ReplicaPicker); isReplica {
+		peers = rp.PickPeers(key)
+	} else if peer, isRemote := g.peers.PickPeer(key); isRemote {
+		peers = []PeerGetter{peer}
+	}
+	for _, peer := range peers {
+		if peer == skip {
+			continue
+		}
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			g.stats.PeerLoads.Add(1)
+			return value, true
+		}
+		g.stats.PeerErrors.Add(1)
+		log.Println("[myCache] Failed to get from peer", err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return ByteView{}, false
+}
+
+/*
+从本地的回调函数获得key对应的值，按以下顺序选择getter实现的接口：
+ContextTTLGetter传入ctx并使用其返回的过期时间；TTLGetter使用其返回的过期时间；ContextGetter传入ctx。
+*/
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	var bytes []byte
+	var err error
+	var keyTTL time.Duration
+	if ctg, ok := g.getter.(ContextTTLGetter); ok {
+		bytes, keyTTL, err = ctg.GetWithTTLContext(ctx, key)
+	} else if tg, ok := g.getter.(TTLGetter); ok {
+		bytes, keyTTL, err = tg.GetWithTTL(key)
+	} else if cg, ok := g.getter.(ContextGetter); ok {
+		bytes, err = cg.GetContext(ctx, key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
+		g.stats.LocalLoadErrors.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{data: cloneBytes(bytes)}
-	g.populateCache(key, value)
-	return value, nil
+	return g.storeLoaded(key, bytes, keyTTL), nil
+}
+
+// 保存从本地导入的值：以当前时间为版本写入缓存和副本。keyTTL大于0时覆盖Conf.DefaultTTL。
+func (g *Group) storeLoaded(key string, bytes []byte, keyTTL time.Duration) ByteView {
+	ttl := g.defaultTTL
+	if keyTTL > 0 {
+		ttl = keyTTL
+	}
+	g.stats.LocalLoads.Add(1)
+	value := ByteView{data: cloneBytes(bytes), version: newVersion(), expire: expireAt(ttl)}
+	g.populateCache(key, value, ttl)
+	g.replicatePopulate(key, value)
+	return value
 }
 
 // 添加数据到缓存器
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+func (g *Group) populateCache(key string, value ByteView, ttl time.Duration) {
+	g.mainCache.add(key, value, ttl)
 }
 
-// 利用【数据获得器】peer，从远程节点获得key对应的值。
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// 利用【数据获得器】peer，从远程节点获得key对应的值。peer实现了ContextPeerGetter时传入ctx。
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.KVResponse{}
-	err := peer.Get(req, res)
+	var err error
+	if cp, ok := peer.(ContextPeerGetter); ok {
+		err = cp.GetContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{data: res.Value}, nil
+	return ByteView{data: res.Value, version: res.Timestamp}, nil
+}
+
+// 利用【数据获得器】peer，将key对应的值写入远程节点。
+func (g *Group) setToPeer(peer PeerGetter, key string, value []byte) error {
+	req := &pb.SetRequest{
+		Group: g.name,
+		Key:   key,
+		Value: value,
+	}
+	return peer.Set(req)
 }
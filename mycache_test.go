@@ -0,0 +1,175 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 新建Group，测试结束时关闭它，停止后台清理并从全局注册表中移除。
+func newTestGroup(t *testing.T, conf Conf, cacheBytes int64, getter Getter) *Group {
+	t.Helper()
+	g := NewGroup(conf, cacheBytes, getter)
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+/*
+在同一进程内模拟另一个节点：返回的HTTPPool把发往名为name的Group的请求交给remote处理。
+全部Group共用同一个注册表，remote需要以其他名称注册，就像它运行在另一个进程中的同名Group。
+*/
+func newRemoteNode(t *testing.T, self, name string, remote *Group) (*HTTPPool, *httptest.Server) {
+	t.Helper()
+	pool := NewHTTPPool(self)
+	pool.getGroup = func(group string) *Group {
+		if group == name {
+			return remote
+		}
+		return nil
+	}
+	server := httptest.NewServer(pool)
+	t.Cleanup(server.Close)
+	return pool, server
+}
+
+func TestDefaultTTLReload(t *testing.T) {
+	loads := 0
+	g := newTestGroup(t, Conf{Name: "default-ttl", DefaultTTL: 50 * time.Millisecond}, 2<<10,
+		GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte("v"), nil
+		}))
+	g.Get("k")
+	g.Get("k")
+	if loads != 1 {
+		t.Fatalf("loads = %d before the entry expired, want 1", loads)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if v, err := g.Get("k"); err != nil || v.String() != "v" || loads != 2 {
+		t.Fatalf("get k = %q, %v after %d loads; want the expired entry reloaded", v.String(), err, loads)
+	}
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if GetGroup("default-ttl") != nil {
+		t.Fatal("closed group is still registered")
+	}
+	if err := g.Close(); err != nil { // 重复关闭是安全的
+		t.Fatal(err)
+	}
+}
+
+// 同时实现ContextGetter和TTLGetter的getter。
+type contextAndTTLGetter struct{ loads *int }
+
+func (g contextAndTTLGetter) Get(key string) ([]byte, error) { return []byte("v"), nil }
+
+func (g contextAndTTLGetter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	*g.loads++
+	return []byte("v"), nil
+}
+
+func (g contextAndTTLGetter) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	*g.loads++
+	return []byte("v"), 30 * time.Millisecond, nil
+}
+
+func TestGetterTTLWithContext(t *testing.T) {
+	loads := 0
+	g := newTestGroup(t, Conf{Name: "context-and-ttl"}, 2<<10, contextAndTTLGetter{&loads})
+	g.Get("k")
+	time.Sleep(60 * time.Millisecond)
+	g.Get("k")
+	if loads != 2 {
+		t.Fatalf("loads = %d, want the per-key TTL kept for a getter that also implements ContextGetter", loads)
+	}
+}
+
+func TestLoadLeaderCanceled(t *testing.T) {
+	started := make(chan struct{})
+	var calls int32
+	g := newTestGroup(t, Conf{Name: "leader-canceled"}, 2<<10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-ctx.Done() // 第一次导入一直等到发起者的ctx被取消
+			return nil, ctx.Err()
+		}
+		return []byte("v"), nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(ctx, "k")
+		leader <- err
+	}()
+	<-started
+	waiter := make(chan ByteView, 1)
+	go func() {
+		v, err := g.Get("k")
+		if err != nil {
+			t.Error("waiter failed because the leader was canceled: ", err)
+		}
+		waiter <- v
+	}()
+	time.Sleep(20 * time.Millisecond) // 等待第二个请求加入single flight
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader error = %v, want context.Canceled", err)
+	}
+	if v := <-waiter; v.String() != "v" {
+		t.Fatalf("waiter got %q, want the value loaded again", v.String())
+	}
+}
+
+// 与HTTPPool内部接口冲突的名称不能用作Group的名称。
+func TestReservedGroupNames(t *testing.T) {
+	for name := range reservedGroupNames {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewGroup(%q) should panic", name)
+				}
+			}()
+			NewGroup(Conf{Name: name}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+				return nil, nil
+			}))
+		}()
+	}
+}
+
+// 从持久化文件恢复的记录以写入时间加上默认有效期为过期时间，重启不会延长有效期，已过期的记录需要重新导入。
+func TestDefaultTTLRestore(t *testing.T) {
+	dir := t.TempDir()
+	conf := Conf{Name: "default-ttl-restore", DefaultTTL: 200 * time.Millisecond, EnablePersistence: true, PersistencePath: dir}
+	g := NewGroup(conf, 2<<10, originGetter())
+	g.Set("old", []byte("v"))
+	time.Sleep(250 * time.Millisecond)
+	g.Set("new", []byte("v"))
+	backup := filepath.Join(t.TempDir(), "backup.data")
+	if err := g.mainCache.writeSequence.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+
+	loads := 0
+	conf.FullPersistentFile = backup
+	g = newTestGroup(t, conf, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("loaded"), nil
+	}))
+	if v, err := g.Get("new"); err != nil || v.String() != "v" || loads != 0 {
+		t.Fatalf("get new = %q, %v after %d loads; want the restored value", v.String(), err, loads)
+	}
+	if v, err := g.Get("old"); err != nil || v.String() != "loaded" || loads != 1 {
+		t.Fatalf("get old = %q, %v after %d loads; want the expired entry reloaded", v.String(), err, loads)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if v, err := g.Get("new"); err != nil || v.String() != "loaded" || loads != 2 {
+		t.Fatalf("get new = %q, %v after %d loads; want it expired 200ms after it was written", v.String(), err, loads)
+	}
+}
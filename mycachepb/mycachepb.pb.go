@@ -9,6 +9,7 @@ package mycachepb
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -76,6 +77,8 @@ func (x *Request) GetKey() string {
 type KVResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp     uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Expire        int64                  `protobuf:"varint,3,opt,name=expire,proto3" json:"expire,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -117,18 +120,462 @@ func (x *KVResponse) GetValue() []byte {
 	return nil
 }
 
+func (x *KVResponse) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *KVResponse) GetExpire() int64 {
+	if x != nil {
+		return x.Expire
+	}
+	return 0
+}
+
+type SetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp     uint64                 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Expire        int64                  `protobuf:"varint,5,opt,name=expire,proto3" json:"expire,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetRequest) Reset() {
+	*x = SetRequest{}
+	mi := &file_mycachepb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRequest) ProtoMessage() {}
+
+func (x *SetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRequest.ProtoReflect.Descriptor instead.
+func (*SetRequest) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SetRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SetRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SetRequest) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *SetRequest) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *SetRequest) GetExpire() int64 {
+	if x != nil {
+		return x.Expire
+	}
+	return 0
+}
+
+type MultiRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys          []string               `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiRequest) Reset() {
+	*x = MultiRequest{}
+	mi := &file_mycachepb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiRequest) ProtoMessage() {}
+
+func (x *MultiRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiRequest.ProtoReflect.Descriptor instead.
+func (*MultiRequest) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MultiRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *MultiRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type KVResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KVResult) Reset() {
+	*x = KVResult{}
+	mi := &file_mycachepb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KVResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KVResult) ProtoMessage() {}
+
+func (x *KVResult) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KVResult.ProtoReflect.Descriptor instead.
+func (*KVResult) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *KVResult) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *KVResult) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *KVResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type MultiResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*KVResult            `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiResponse) Reset() {
+	*x = MultiResponse{}
+	mi := &file_mycachepb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiResponse) ProtoMessage() {}
+
+func (x *MultiResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiResponse.ProtoReflect.Descriptor instead.
+func (*MultiResponse) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *MultiResponse) GetResults() []*KVResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type GroupStats struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Gets            int64                  `protobuf:"varint,1,opt,name=gets,proto3" json:"gets,omitempty"`
+	Hits            int64                  `protobuf:"varint,2,opt,name=hits,proto3" json:"hits,omitempty"`
+	Misses          int64                  `protobuf:"varint,3,opt,name=misses,proto3" json:"misses,omitempty"`
+	PeerLoads       int64                  `protobuf:"varint,4,opt,name=peer_loads,json=peerLoads,proto3" json:"peer_loads,omitempty"`
+	PeerErrors      int64                  `protobuf:"varint,5,opt,name=peer_errors,json=peerErrors,proto3" json:"peer_errors,omitempty"`
+	LocalLoads      int64                  `protobuf:"varint,6,opt,name=local_loads,json=localLoads,proto3" json:"local_loads,omitempty"`
+	LocalLoadErrors int64                  `protobuf:"varint,7,opt,name=local_load_errors,json=localLoadErrors,proto3" json:"local_load_errors,omitempty"`
+	DedupedLoads    int64                  `protobuf:"varint,8,opt,name=deduped_loads,json=dedupedLoads,proto3" json:"deduped_loads,omitempty"`
+	Evictions       int64                  `protobuf:"varint,9,opt,name=evictions,proto3" json:"evictions,omitempty"`
+	DiskHits        int64                  `protobuf:"varint,10,opt,name=disk_hits,json=diskHits,proto3" json:"disk_hits,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *GroupStats) Reset() {
+	*x = GroupStats{}
+	mi := &file_mycachepb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupStats) ProtoMessage() {}
+
+func (x *GroupStats) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupStats.ProtoReflect.Descriptor instead.
+func (*GroupStats) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GroupStats) GetGets() int64 {
+	if x != nil {
+		return x.Gets
+	}
+	return 0
+}
+
+func (x *GroupStats) GetHits() int64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *GroupStats) GetMisses() int64 {
+	if x != nil {
+		return x.Misses
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPeerLoads() int64 {
+	if x != nil {
+		return x.PeerLoads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPeerErrors() int64 {
+	if x != nil {
+		return x.PeerErrors
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLocalLoads() int64 {
+	if x != nil {
+		return x.LocalLoads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLocalLoadErrors() int64 {
+	if x != nil {
+		return x.LocalLoadErrors
+	}
+	return 0
+}
+
+func (x *GroupStats) GetDedupedLoads() int64 {
+	if x != nil {
+		return x.DedupedLoads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetEvictions() int64 {
+	if x != nil {
+		return x.Evictions
+	}
+	return 0
+}
+
+func (x *GroupStats) GetDiskHits() int64 {
+	if x != nil {
+		return x.DiskHits
+	}
+	return 0
+}
+
+type PeerState struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Peer                string                 `protobuf:"bytes,1,opt,name=peer,proto3" json:"peer,omitempty"`
+	BreakerState        string                 `protobuf:"bytes,2,opt,name=breaker_state,json=breakerState,proto3" json:"breaker_state,omitempty"`
+	ConsecutiveFailures int64                  `protobuf:"varint,3,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	Up                  bool                   `protobuf:"varint,4,opt,name=up,proto3" json:"up,omitempty"`
+	Inflight            int64                  `protobuf:"varint,5,opt,name=inflight,proto3" json:"inflight,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *PeerState) Reset() {
+	*x = PeerState{}
+	mi := &file_mycachepb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PeerState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerState) ProtoMessage() {}
+
+func (x *PeerState) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerState.ProtoReflect.Descriptor instead.
+func (*PeerState) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PeerState) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *PeerState) GetBreakerState() string {
+	if x != nil {
+		return x.BreakerState
+	}
+	return ""
+}
+
+func (x *PeerState) GetConsecutiveFailures() int64 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (x *PeerState) GetUp() bool {
+	if x != nil {
+		return x.Up
+	}
+	return false
+}
+
+func (x *PeerState) GetInflight() int64 {
+	if x != nil {
+		return x.Inflight
+	}
+	return 0
+}
+
 type InfoResponse struct {
 	state            protoimpl.MessageState `protogen:"open.v1"`
 	KeysNum          int64                  `protobuf:"varint,1,opt,name=keysNum,proto3" json:"keysNum,omitempty"`
 	CurrentUsedBytes int64                  `protobuf:"varint,2,opt,name=current_used_bytes,json=currentUsedBytes,proto3" json:"current_used_bytes,omitempty"`
 	MaxUsedBytes     int64                  `protobuf:"varint,3,opt,name=max_used_bytes,json=maxUsedBytes,proto3" json:"max_used_bytes,omitempty"`
+	Stats            *GroupStats            `protobuf:"bytes,4,opt,name=stats,proto3" json:"stats,omitempty"`
+	Peers            []*PeerState           `protobuf:"bytes,5,rep,name=peers,proto3" json:"peers,omitempty"`
+	Rebalance        *RebalanceState        `protobuf:"bytes,6,opt,name=rebalance,proto3" json:"rebalance,omitempty"`
+	DiskLiveBytes    int64                  `protobuf:"varint,7,opt,name=disk_live_bytes,json=diskLiveBytes,proto3" json:"disk_live_bytes,omitempty"`
+	DiskDeadBytes    int64                  `protobuf:"varint,8,opt,name=disk_dead_bytes,json=diskDeadBytes,proto3" json:"disk_dead_bytes,omitempty"`
+	DiskKeysNum      int64                  `protobuf:"varint,9,opt,name=disk_keys_num,json=diskKeysNum,proto3" json:"disk_keys_num,omitempty"`
 	unknownFields    protoimpl.UnknownFields
 	sizeCache        protoimpl.SizeCache
 }
 
 func (x *InfoResponse) Reset() {
 	*x = InfoResponse{}
-	mi := &file_mycachepb_proto_msgTypes[2]
+	mi := &file_mycachepb_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -140,7 +587,7 @@ func (x *InfoResponse) String() string {
 func (*InfoResponse) ProtoMessage() {}
 
 func (x *InfoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_mycachepb_proto_msgTypes[2]
+	mi := &file_mycachepb_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -153,7 +600,7 @@ func (x *InfoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use InfoResponse.ProtoReflect.Descriptor instead.
 func (*InfoResponse) Descriptor() ([]byte, []int) {
-	return file_mycachepb_proto_rawDescGZIP(), []int{2}
+	return file_mycachepb_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *InfoResponse) GetKeysNum() int64 {
@@ -177,24 +624,334 @@ func (x *InfoResponse) GetMaxUsedBytes() int64 {
 	return 0
 }
 
+func (x *InfoResponse) GetStats() *GroupStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+func (x *InfoResponse) GetPeers() []*PeerState {
+	if x != nil {
+		return x.Peers
+	}
+	return nil
+}
+
+func (x *InfoResponse) GetRebalance() *RebalanceState {
+	if x != nil {
+		return x.Rebalance
+	}
+	return nil
+}
+
+func (x *InfoResponse) GetDiskLiveBytes() int64 {
+	if x != nil {
+		return x.DiskLiveBytes
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDiskDeadBytes() int64 {
+	if x != nil {
+		return x.DiskDeadBytes
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDiskKeysNum() int64 {
+	if x != nil {
+		return x.DiskKeysNum
+	}
+	return 0
+}
+
+type RebalanceState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Running       bool                   `protobuf:"varint,1,opt,name=running,proto3" json:"running,omitempty"`
+	Total         int64                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Scanned       int64                  `protobuf:"varint,3,opt,name=scanned,proto3" json:"scanned,omitempty"`
+	Moved         int64                  `protobuf:"varint,4,opt,name=moved,proto3" json:"moved,omitempty"`
+	Failed        int64                  `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RebalanceState) Reset() {
+	*x = RebalanceState{}
+	mi := &file_mycachepb_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RebalanceState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RebalanceState) ProtoMessage() {}
+
+func (x *RebalanceState) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RebalanceState.ProtoReflect.Descriptor instead.
+func (*RebalanceState) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RebalanceState) GetRunning() bool {
+	if x != nil {
+		return x.Running
+	}
+	return false
+}
+
+func (x *RebalanceState) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *RebalanceState) GetScanned() int64 {
+	if x != nil {
+		return x.Scanned
+	}
+	return 0
+}
+
+func (x *RebalanceState) GetMoved() int64 {
+	if x != nil {
+		return x.Moved
+	}
+	return 0
+}
+
+func (x *RebalanceState) GetFailed() int64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+type GossipMember struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Addr          string                 `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	State         int32                  `protobuf:"varint,2,opt,name=state,proto3" json:"state,omitempty"`
+	Incarnation   uint64                 `protobuf:"varint,3,opt,name=incarnation,proto3" json:"incarnation,omitempty"`
+	Weight        int64                  `protobuf:"varint,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GossipMember) Reset() {
+	*x = GossipMember{}
+	mi := &file_mycachepb_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GossipMember) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GossipMember) ProtoMessage() {}
+
+func (x *GossipMember) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GossipMember.ProtoReflect.Descriptor instead.
+func (*GossipMember) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GossipMember) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *GossipMember) GetState() int32 {
+	if x != nil {
+		return x.State
+	}
+	return 0
+}
+
+func (x *GossipMember) GetIncarnation() uint64 {
+	if x != nil {
+		return x.Incarnation
+	}
+	return 0
+}
+
+func (x *GossipMember) GetWeight() int64 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+type GossipMessage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	From          string                 `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Members       []*GossipMember        `protobuf:"bytes,3,rep,name=members,proto3" json:"members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GossipMessage) Reset() {
+	*x = GossipMessage{}
+	mi := &file_mycachepb_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GossipMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GossipMessage) ProtoMessage() {}
+
+func (x *GossipMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_mycachepb_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GossipMessage.ProtoReflect.Descriptor instead.
+func (*GossipMessage) Descriptor() ([]byte, []int) {
+	return file_mycachepb_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GossipMessage) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *GossipMessage) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *GossipMessage) GetMembers() []*GossipMember {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
 var File_mycachepb_proto protoreflect.FileDescriptor
 
 const file_mycachepb_proto_rawDesc = "" +
 	"\n" +
-	"\x0fmycachepb.proto\x12\tmycachepb\"1\n" +
+	"\x0fmycachepb.proto\x12\tmycachepb\x1a\x1bgoogle/protobuf/empty.proto\"1\n" +
 	"\aRequest\x12\x14\n" +
 	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
-	"\x03key\x18\x02 \x01(\tR\x03key\"\"\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\"X\n" +
 	"\n" +
 	"KVResponse\x12\x14\n" +
-	"\x05value\x18\x01 \x01(\fR\x05value\"|\n" +
+	"\x05value\x18\x01 \x01(\fR\x05value\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\x12\x16\n" +
+	"\x06expire\x18\x03 \x01(\x03R\x06expire\"\x80\x01\n" +
+	"\n" +
+	"SetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\x12\x1c\n" +
+	"\ttimestamp\x18\x04 \x01(\x04R\ttimestamp\x12\x16\n" +
+	"\x06expire\x18\x05 \x01(\x03R\x06expire\"8\n" +
+	"\fMultiRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x12\n" +
+	"\x04keys\x18\x02 \x03(\tR\x04keys\"H\n" +
+	"\bKVResult\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\">\n" +
+	"\rMultiResponse\x12-\n" +
+	"\aresults\x18\x01 \x03(\v2\x13.mycachepb.KVResultR\aresults\"\xb9\x02\n" +
+	"\n" +
+	"GroupStats\x12\x12\n" +
+	"\x04gets\x18\x01 \x01(\x03R\x04gets\x12\x12\n" +
+	"\x04hits\x18\x02 \x01(\x03R\x04hits\x12\x16\n" +
+	"\x06misses\x18\x03 \x01(\x03R\x06misses\x12\x1d\n" +
+	"\n" +
+	"peer_loads\x18\x04 \x01(\x03R\tpeerLoads\x12\x1f\n" +
+	"\vpeer_errors\x18\x05 \x01(\x03R\n" +
+	"peerErrors\x12\x1f\n" +
+	"\vlocal_loads\x18\x06 \x01(\x03R\n" +
+	"localLoads\x12*\n" +
+	"\x11local_load_errors\x18\a \x01(\x03R\x0flocalLoadErrors\x12#\n" +
+	"\rdeduped_loads\x18\b \x01(\x03R\fdedupedLoads\x12\x1c\n" +
+	"\tevictions\x18\t \x01(\x03R\tevictions\x12\x1b\n" +
+	"\tdisk_hits\x18\n" +
+	" \x01(\x03R\bdiskHits\"\xa3\x01\n" +
+	"\tPeerState\x12\x12\n" +
+	"\x04peer\x18\x01 \x01(\tR\x04peer\x12#\n" +
+	"\rbreaker_state\x18\x02 \x01(\tR\fbreakerState\x121\n" +
+	"\x14consecutive_failures\x18\x03 \x01(\x03R\x13consecutiveFailures\x12\x0e\n" +
+	"\x02up\x18\x04 \x01(\bR\x02up\x12\x1a\n" +
+	"\binflight\x18\x05 \x01(\x03R\binflight\"\x82\x03\n" +
 	"\fInfoResponse\x12\x18\n" +
 	"\akeysNum\x18\x01 \x01(\x03R\akeysNum\x12,\n" +
 	"\x12current_used_bytes\x18\x02 \x01(\x03R\x10currentUsedBytes\x12$\n" +
-	"\x0emax_used_bytes\x18\x03 \x01(\x03R\fmaxUsedBytes2>\n" +
+	"\x0emax_used_bytes\x18\x03 \x01(\x03R\fmaxUsedBytes\x12+\n" +
+	"\x05stats\x18\x04 \x01(\v2\x15.mycachepb.GroupStatsR\x05stats\x12*\n" +
+	"\x05peers\x18\x05 \x03(\v2\x14.mycachepb.PeerStateR\x05peers\x127\n" +
+	"\trebalance\x18\x06 \x01(\v2\x19.mycachepb.RebalanceStateR\trebalance\x12&\n" +
+	"\x0fdisk_live_bytes\x18\a \x01(\x03R\rdiskLiveBytes\x12&\n" +
+	"\x0fdisk_dead_bytes\x18\b \x01(\x03R\rdiskDeadBytes\x12\"\n" +
+	"\rdisk_keys_num\x18\t \x01(\x03R\vdiskKeysNum\"\x88\x01\n" +
+	"\x0eRebalanceState\x12\x18\n" +
+	"\arunning\x18\x01 \x01(\bR\arunning\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\x12\x18\n" +
+	"\ascanned\x18\x03 \x01(\x03R\ascanned\x12\x14\n" +
+	"\x05moved\x18\x04 \x01(\x03R\x05moved\x12\x16\n" +
+	"\x06failed\x18\x05 \x01(\x03R\x06failed\"r\n" +
+	"\fGossipMember\x12\x12\n" +
+	"\x04addr\x18\x01 \x01(\tR\x04addr\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\x05R\x05state\x12 \n" +
+	"\vincarnation\x18\x03 \x01(\x04R\vincarnation\x12\x16\n" +
+	"\x06weight\x18\x04 \x01(\x03R\x06weight\"n\n" +
+	"\rGossipMessage\x12\x12\n" +
+	"\x04from\x18\x01 \x01(\tR\x04from\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x121\n" +
+	"\amembers\x18\x03 \x03(\v2\x17.mycachepb.GossipMemberR\amembers2\xe9\x01\n" +
 	"\n" +
 	"GroupCache\x120\n" +
-	"\x03Get\x12\x12.mycachepb.Request\x1a\x15.mycachepb.KVResponseB\x0eZ\f./;mycachepbb\x06proto3"
+	"\x03Get\x12\x12.mycachepb.Request\x1a\x15.mycachepb.KVResponse\x124\n" +
+	"\x03Set\x12\x15.mycachepb.SetRequest\x1a\x16.google.protobuf.Empty\x124\n" +
+	"\x06Delete\x12\x12.mycachepb.Request\x1a\x16.google.protobuf.Empty\x12=\n" +
+	"\bGetMulti\x12\x17.mycachepb.MultiRequest\x1a\x18.mycachepb.MultiResponseB\x0eZ\f./;mycachepbb\x06proto3"
 
 var (
 	file_mycachepb_proto_rawDescOnce sync.Once
@@ -208,20 +965,41 @@ func file_mycachepb_proto_rawDescGZIP() []byte {
 	return file_mycachepb_proto_rawDescData
 }
 
-var file_mycachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_mycachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_mycachepb_proto_goTypes = []any{
-	(*Request)(nil),      // 0: mycachepb.Request
-	(*KVResponse)(nil),   // 1: mycachepb.KVResponse
-	(*InfoResponse)(nil), // 2: mycachepb.InfoResponse
+	(*Request)(nil),        // 0: mycachepb.Request
+	(*KVResponse)(nil),     // 1: mycachepb.KVResponse
+	(*SetRequest)(nil),     // 2: mycachepb.SetRequest
+	(*MultiRequest)(nil),   // 3: mycachepb.MultiRequest
+	(*KVResult)(nil),       // 4: mycachepb.KVResult
+	(*MultiResponse)(nil),  // 5: mycachepb.MultiResponse
+	(*GroupStats)(nil),     // 6: mycachepb.GroupStats
+	(*PeerState)(nil),      // 7: mycachepb.PeerState
+	(*InfoResponse)(nil),   // 8: mycachepb.InfoResponse
+	(*RebalanceState)(nil), // 9: mycachepb.RebalanceState
+	(*GossipMember)(nil),   // 10: mycachepb.GossipMember
+	(*GossipMessage)(nil),  // 11: mycachepb.GossipMessage
+	(*emptypb.Empty)(nil),  // 12: google.protobuf.Empty
 }
 var file_mycachepb_proto_depIdxs = []int32{
-	0, // 0: mycachepb.GroupCache.Get:input_type -> mycachepb.Request
-	1, // 1: mycachepb.GroupCache.Get:output_type -> mycachepb.KVResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4,  // 0: mycachepb.MultiResponse.results:type_name -> mycachepb.KVResult
+	6,  // 1: mycachepb.InfoResponse.stats:type_name -> mycachepb.GroupStats
+	7,  // 2: mycachepb.InfoResponse.peers:type_name -> mycachepb.PeerState
+	9,  // 3: mycachepb.InfoResponse.rebalance:type_name -> mycachepb.RebalanceState
+	10, // 4: mycachepb.GossipMessage.members:type_name -> mycachepb.GossipMember
+	0,  // 5: mycachepb.GroupCache.Get:input_type -> mycachepb.Request
+	2,  // 6: mycachepb.GroupCache.Set:input_type -> mycachepb.SetRequest
+	0,  // 7: mycachepb.GroupCache.Delete:input_type -> mycachepb.Request
+	3,  // 8: mycachepb.GroupCache.GetMulti:input_type -> mycachepb.MultiRequest
+	1,  // 9: mycachepb.GroupCache.Get:output_type -> mycachepb.KVResponse
+	12, // 10: mycachepb.GroupCache.Set:output_type -> google.protobuf.Empty
+	12, // 11: mycachepb.GroupCache.Delete:output_type -> google.protobuf.Empty
+	5,  // 12: mycachepb.GroupCache.GetMulti:output_type -> mycachepb.MultiResponse
+	9,  // [9:13] is the sub-list for method output_type
+	5,  // [5:9] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_mycachepb_proto_init() }
@@ -235,7 +1013,7 @@ func file_mycachepb_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mycachepb_proto_rawDesc), len(file_mycachepb_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
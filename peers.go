@@ -1,6 +1,10 @@
 package mycache
 
-import pb "mycache/mycachepb"
+import (
+	"context"
+
+	pb "mycache/mycachepb"
+)
 
 /*
 节点选择器。
@@ -9,10 +13,31 @@ PeerPicker里面需要有节点选择算法，如取余数法等等。本框架
 */
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool) // 根据传入的 key 选择相应节点 PeerGetter。
+	GetAll() []PeerGetter                           // 返回全部远程节点（不包括本节点）的 PeerGetter，用于广播。
+}
+
+/*
+OwnerPicker 是可选的PeerPicker扩展，返回key在一致性哈希上的归属节点，与节点的负载无关；key由本节点负责时ok为false。
+写入和批量获取按它选择节点，各节点看到的负载不同时，key的归属仍然一致且稳定。未实现时使用PickPeer。
+*/
+type OwnerPicker interface {
+	PickOwner(key string) (peer PeerGetter, ok bool)
 }
 
 /*
-PeerGetter是一个【数据获得器】接口，实现该接口的结构体必须：能从指定group获得指定key对应的值，并返回。
+ReplicaPicker 是可选的PeerPicker扩展，按优先顺序返回key的主节点和副本节点。
+Group从远程节点获取失败时，依次尝试后面的副本节点，全部失败后才回退到本地导入。
+本节点也是副本之一时，列表在本节点之前截止，由本节点直接导入；返回空列表表示key由本节点负责，第一个元素与PickPeer相同。
+*/
+type ReplicaPicker interface {
+	PickPeers(key string) []PeerGetter
+	PickReplicas(key string) (peers []PeerGetter, self bool) // 返回key的全部副本中的远程节点，以及本节点是否也是副本之一
+}
+
+/*
+PeerGetter是一个【数据获得器】接口，实现该接口的结构体必须：能从指定group获得指定key对应的值，并返回；
+也能将指定key的值写入远程节点（Set），或从远程节点删除（Delete）。
+Delete只删除远程节点本地的数据，不会再由远程节点继续转发或广播。
 由于是分布式框架，实现PeerGetter的结构体需要具有从别的节点获得数据的能力。
 不同节点之间的通信方式有很多种，比如蓝牙、直接使用USB数据线传输。
 本框架内仅实现了基于HTTP的【数据获得器】：httpGetter。
@@ -21,4 +46,30 @@ PeerGetter是一个【数据获得器】接口，实现该接口的结构体必
 */
 type PeerGetter interface {
 	Get(in *pb.Request, out *pb.KVResponse) error
+	Set(in *pb.SetRequest) error
+	Delete(in *pb.Request) error
+}
+
+/*
+ContextPeerGetter 是支持 context 的PeerGetter，ctx被取消或超时时，向远程节点发出的请求随之中止。
+PeerGetter.Get 相当于以context.Background()调用GetContext。
+*/
+type ContextPeerGetter interface {
+	PeerGetter
+	GetContext(ctx context.Context, in *pb.Request, out *pb.KVResponse) error
+}
+
+// PeerBatchGetter 是可选的批量【数据获得器】，一次请求获得远程节点上的多个key，res.Results与req.Keys一一对应。
+type PeerBatchGetter interface {
+	GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) error
+}
+
+/*
+ReplicaGetter 是可选的【数据获得器】，只读写远程节点本地保存的副本，既不转发给其他节点，也不回源。
+GetReplica在远程节点没有该key的副本时也成功返回，out.Timestamp为0；
+SetReplica以in.Timestamp为版本写入，远程节点保存着更新的版本时忽略本次写入。
+*/
+type ReplicaGetter interface {
+	GetReplica(ctx context.Context, in *pb.Request, out *pb.KVResponse) error
+	SetReplica(ctx context.Context, in *pb.SetRequest) error
 }
@@ -0,0 +1,274 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/*
+后台压缩：每个数据段的有效数据（索引仍指向的记录）和无效数据（被覆盖或删除的记录，以及删除标记）分别统计。
+每隔CompactInterval检查一次已封存的数据段：无效数据的比例达到CompactRatio的数据段，
+或者全部已封存数据段的无效数据合计达到CompactBytes时含有无效数据的全部数据段，会被压缩。
+压缩按编号从小到大逐个处理数据段：将其中仍有效的记录重新追加到活跃段，落盘后删除该数据段及其提示文件。
+读取旧记录时不持有写锁，每条记录只在追加时短暂持有写锁，因此压缩期间Put和Get可以正常进行；
+追加前会再次检查索引，压缩期间被覆盖或删除的记录不会被写回。
+删除标记只在编号更小的数据段中还有被删除的key的PUT记录时才需要保留（否则重建索引时旧值会复活），此时也被追加到活跃段；
+是否有这样的记录按这些数据段的提示文件判断。
+*/
+const (
+	DefaultCompactRatio    = 0.5
+	DefaultCompactBytes    = 1 << 30
+	DefaultCompactInterval = time.Minute
+)
+
+var errCompactionStopped = errors.New("compaction stopped by Close")
+
+// DiskUsage 是数据目录的磁盘使用情况，不包括文件头和提示文件。
+type DiskUsage struct {
+	LiveBytes   int64 // 有效记录占用的字节数
+	DeadBytes   int64 // 被覆盖或删除的记录以及删除标记占用的字节数
+	Segments    int   // 数据段数量，包括活跃段
+	Compactions int64 // 已完成压缩的数据段数量
+}
+
+// DiskUsage 返回数据目录的磁盘使用情况。
+func (w *WriteSequence) DiskUsage() DiskUsage {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	usage := DiskUsage{Segments: len(w.segments), Compactions: atomic.LoadInt64(&w.compactions)}
+	for id, f := range w.segments {
+		total := f.GetOffset() - FileHeaderSize
+		usage.LiveBytes += w.live[id]
+		usage.DeadBytes += total - w.live[id]
+	}
+	return usage
+}
+
+// 将key的最新记录更新为loc，并更新各数据段的有效数据量。调用者需持有w.mutex的写锁。
+func (w *WriteSequence) setLocation(key string, loc Location) {
+	if old, ok := w.IsKeyExist([]byte(key)); ok {
+		w.live[old.Segment] -= int64(old.Size)
+	}
+	w.index.Store(key, loc)
+	w.live[loc.Segment] += int64(loc.Size)
+}
+
+// 从索引中删除key，并更新所在数据段的有效数据量。调用者需持有w.mutex的写锁。
+func (w *WriteSequence) removeLocation(key string) {
+	if old, ok := w.IsKeyExist([]byte(key)); ok {
+		w.live[old.Segment] -= int64(old.Size)
+		w.index.Delete(key)
+	}
+}
+
+// 按编号从小到大返回需要压缩的已封存数据段。
+func (w *WriteSequence) compactionCandidates() []uint32 {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	var byRatio, withGarbage []uint32
+	var dead int64
+	for id, f := range w.segments {
+		if id == w.activeID {
+			continue
+		}
+		total := f.GetOffset() - FileHeaderSize
+		garbage := total - w.live[id]
+		if garbage <= 0 {
+			continue
+		}
+		dead += garbage
+		withGarbage = append(withGarbage, id)
+		if w.compactRatio >= 0 && float64(garbage) >= w.compactRatio*float64(total) {
+			byRatio = append(byRatio, id)
+		}
+	}
+	ids := byRatio
+	if w.compactBytes >= 0 && dead >= w.compactBytes {
+		ids = withGarbage
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 每隔interval检查一次是否需要压缩，直到Close。
+func (w *WriteSequence) compactLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.compactMu.Lock()
+			if ids := w.compactionCandidates(); len(ids) > 0 {
+				start := time.Now()
+				err := w.compact(ids)
+				w.mergeTime.ObserveSince(start)
+				if err != nil && err != errCompactionStopped {
+					fmt.Println("compactLoop() error: ", err)
+				}
+			}
+			w.compactMu.Unlock()
+		}
+	}
+}
+
+// 按顺序压缩数据段ids。调用者需持有w.compactMu。
+func (w *WriteSequence) compact(ids []uint32) error {
+	for _, id := range ids {
+		if err := w.compactSegment(id); err != nil {
+			return fmt.Errorf("compact segment %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// 将已封存的数据段id中仍有效的记录追加到活跃段，然后删除该数据段。调用者需持有w.compactMu。
+func (w *WriteSequence) compactSegment(id uint32) error {
+	w.mutex.RLock()
+	f, ok := w.segments[id]
+	sealed := id != w.activeID
+	first := w.activeID // 追加的记录写入编号不小于first的数据段，活跃段写满时会切换
+	w.mutex.RUnlock()
+	if !ok || !sealed {
+		return nil
+	}
+	records, err := w.readHints(id)
+	if err != nil {
+		return err
+	}
+	var older map[string]bool // 编号更小的数据段中有PUT记录的key，遇到第一个删除标记时才读取
+	for _, r := range records {
+		select {
+		case <-w.stop:
+			return errCompactionStopped
+		default:
+		}
+		if r.mark == DEL {
+			if older == nil {
+				if older, err = w.olderKeys(id); err != nil {
+					return err
+				}
+			}
+			if err := w.keepTombstone(r, older); err != nil {
+				return err
+			}
+			continue
+		}
+		if cur, ok := w.IsKeyExist([]byte(r.key)); !ok || cur != r.loc {
+			continue // 已被覆盖或删除
+		}
+		entry, err := f.Read(r.loc.Offset) // 数据段已封存，读取时不需要持有写锁
+		if err != nil {
+			return err
+		}
+		w.mutex.Lock()
+		if cur, ok := w.IsKeyExist([]byte(r.key)); ok && cur == r.loc {
+			loc, err := w.write(entry)
+			if err != nil {
+				w.mutex.Unlock()
+				return err
+			}
+			w.setLocation(r.key, loc)
+		}
+		w.mutex.Unlock()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	// 删除数据段前，追加的记录必须已经落盘，与fsync策略无关。
+	for sid, sf := range w.segments {
+		if sid >= first {
+			if err := sf.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := syncDir(w.dataPath); err != nil {
+		return err
+	}
+	_ = f.Close()
+	delete(w.segments, id)
+	delete(w.live, id)
+	if err := os.Remove(filepath.Join(w.dataPath, segmentName(id))); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(w.dataPath, hintName(id))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	atomic.AddInt64(&w.compactions, 1)
+	return nil
+}
+
+// 读取编号比id小的数据段的提示文件，返回其中有PUT记录的key。调用者需持有w.compactMu，这些数据段不会被删除。
+func (w *WriteSequence) olderKeys(id uint32) (map[string]bool, error) {
+	w.mutex.RLock()
+	var ids []uint32
+	for sid := range w.segments {
+		if sid < id {
+			ids = append(ids, sid)
+		}
+	}
+	w.mutex.RUnlock()
+	keys := make(map[string]bool)
+	for _, sid := range ids {
+		records, err := w.readHints(sid)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if r.mark != DEL {
+				keys[r.key] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// 编号更小的数据段中还有key的PUT记录（older），且key仍处于删除状态时，将删除标记追加到活跃段。
+func (w *WriteSequence) keepTombstone(r hintRecord, older map[string]bool) error {
+	if !older[r.key] {
+		return nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, live := w.IsKeyExist([]byte(r.key)); live {
+		return nil
+	}
+	_, err := w.write(NewEntry([]byte(r.key), nil, DEL, r.loc.Timestamp))
+	return err
+}
+
+/*
+Merge 立即压缩全部数据段：先封存活跃段，再按编号从小到大压缩全部已封存的数据段，回收被覆盖和删除的记录占用的磁盘空间。
+与后台压缩相同，Merge期间不阻塞Put和Get。
+*/
+func (w *WriteSequence) Merge() error {
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+	w.mutex.Lock()
+	if !w.databaseFile.IsOffsetEqual(FileHeaderSize) {
+		if err := w.rotate(); err != nil {
+			w.mutex.Unlock()
+			return err
+		}
+	}
+	ids := make([]uint32, 0, len(w.segments))
+	for id := range w.segments {
+		if id != w.activeID {
+			ids = append(ids, id)
+		}
+	}
+	w.mutex.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	defer w.mergeTime.ObserveSince(time.Now())
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return w.compact(ids)
+}
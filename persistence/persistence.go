@@ -1,18 +1,43 @@
 package persistence
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"mycache/metrics"
+)
+
+/*
+数据文件的格式：文件头FileHeaderSize字节，依次为魔数Magic和格式版本FormatVersion（uint32），之后是连续的记录。
+每条记录的头部HeaderSize字节，依次为CRC、KeySize、ValueSize、Mark（均为uint32）和Timestamp（uint64），之后是key和value。
+CRC是头部中CRC之后的全部字段以及key和value的CRC-32（Castagnoli），读取时校验，用于发现未写完或损坏的记录。
+没有文件头的旧格式文件（记录头部为20字节，没有CRC）在打开时会被转换为当前格式。
+*/
+const (
+	HeaderSize       = 24 // (32*4+64)/8
+	legacyHeaderSize = 20 // 旧格式的记录头部，没有CRC
+	FileHeaderSize   = 8
+	FormatVersion    = 1
 )
 
-const HeaderSize = 20 // (32*3+64)/8
+// Magic 是数据文件开头的魔数。
+var Magic = []byte("MYCA")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrChecksum       = errors.New("persistence: entry checksum mismatch")
+	ErrUnknownVersion = errors.New("persistence: unknown data file version")
+)
 
 type Entry struct {
 	Key       []byte
@@ -21,13 +46,11 @@ type Entry struct {
 	ValueSize uint32
 	Mark      uint32
 	Timestamp uint64
+	CRC       uint32
 }
 
-var (
-	DataFileName       = "append.data"
-	MergeFileName      = "append.data.merge"
-	DataBackupFileName = "append.data.bak"
-)
+// DataFileName 是旧版本的单个数据文件的名称，打开时作为第一个数据段。备份文件也以它为前缀命名。
+var DataFileName = "append.data"
 
 const (
 	PUT = iota
@@ -47,38 +70,51 @@ func NewEntry(Key, Value []byte, Mark uint32, Timestamp uint64) *Entry {
 }
 
 func (entry *Entry) Size() uint64 {
-	return uint64(entry.KeySize + entry.ValueSize + HeaderSize)
+	return uint64(entry.KeySize) + uint64(entry.ValueSize) + HeaderSize
 }
 
 func (entry *Entry) Encode() []byte {
 	result := make([]byte, entry.Size())
-	binary.BigEndian.PutUint32(result[:4], entry.KeySize)
-	binary.BigEndian.PutUint32(result[4:8], entry.ValueSize)
-	binary.BigEndian.PutUint32(result[8:12], entry.Mark)
-	binary.BigEndian.PutUint64(result[12:HeaderSize], entry.Timestamp)
+	binary.BigEndian.PutUint32(result[4:8], entry.KeySize)
+	binary.BigEndian.PutUint32(result[8:12], entry.ValueSize)
+	binary.BigEndian.PutUint32(result[12:16], entry.Mark)
+	binary.BigEndian.PutUint64(result[16:HeaderSize], entry.Timestamp)
 	copy(result[HeaderSize:HeaderSize+entry.KeySize], entry.Key)
 	copy(result[HeaderSize+entry.KeySize:entry.Size()], entry.Value)
+	entry.CRC = crc32.Checksum(result[4:], crcTable)
+	binary.BigEndian.PutUint32(result[:4], entry.CRC)
 	return result
 }
 
+// Decode 解析记录的头部，不包括key和value，也不校验CRC。
 func Decode(date []byte) (*Entry, error) {
 	date_size := len(date)
 	if date_size < HeaderSize {
 		return nil, errors.New("invalid data")
 	}
-	KeySize := binary.BigEndian.Uint32(date[:4])
-	ValueSize := binary.BigEndian.Uint32(date[4:8])
-	Mark := binary.BigEndian.Uint32(date[8:12])
-	Timestamp := binary.BigEndian.Uint64(date[12:HeaderSize])
+	CRC := binary.BigEndian.Uint32(date[:4])
+	KeySize := binary.BigEndian.Uint32(date[4:8])
+	ValueSize := binary.BigEndian.Uint32(date[8:12])
+	Mark := binary.BigEndian.Uint32(date[12:16])
+	Timestamp := binary.BigEndian.Uint64(date[16:HeaderSize])
 
 	return &Entry{
 			KeySize:   KeySize,
 			ValueSize: ValueSize,
 			Mark:      Mark,
-			Timestamp: Timestamp},
+			Timestamp: Timestamp,
+			CRC:       CRC},
 		nil
 }
 
+// 校验记录的CRC，data是完整的记录。
+func (entry *Entry) verify(data []byte) error {
+	if crc32.Checksum(data[4:], crcTable) != entry.CRC {
+		return ErrChecksum
+	}
+	return nil
+}
+
 type DatabaseFile struct {
 	File   *os.File
 	offset int64 // 偏移量
@@ -102,12 +138,98 @@ func new(path_file string) (*DatabaseFile, error) {
 		New: func() any {
 			return make([]byte, HeaderSize)
 		}}
-	return &DatabaseFile{
+	f := &DatabaseFile{
 		File:   file,
 		offset: file_info.Size(),
 		Pool:   pool,
 		mutex:  sync.RWMutex{},
-	}, nil
+	}
+	legacy, err := f.checkHeader()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if legacy {
+		_ = file.Close()
+		if err := upgradeLegacy(path_file); err != nil {
+			return nil, err
+		}
+		return new(path_file)
+	}
+	return f, nil
+}
+
+/*
+检查文件头，新文件写入文件头。legacy为true表示这是没有文件头的旧格式文件。
+文件头本身没有写完（文件短于FileHeaderSize且内容是魔数的前缀）时，重新写入文件头。
+*/
+func (f *DatabaseFile) checkHeader() (legacy bool, err error) {
+	size := f.GetOffset()
+	header := make([]byte, FileHeaderSize)
+	if size < FileHeaderSize {
+		n, _ := f.File.ReadAt(header[:size], 0)
+		if !bytes.HasPrefix(Magic, header[:n]) {
+			return true, nil
+		}
+		if err := f.File.Truncate(0); err != nil {
+			return false, err
+		}
+		copy(header, Magic)
+		binary.BigEndian.PutUint32(header[len(Magic):], FormatVersion)
+		if _, err := f.File.WriteAt(header, 0); err != nil {
+			return false, err
+		}
+		f.UpdateOffset(FileHeaderSize)
+		return false, nil
+	}
+	if _, err := f.File.ReadAt(header, 0); err != nil {
+		return false, err
+	}
+	if !bytes.Equal(header[:len(Magic)], Magic) {
+		return true, nil
+	}
+	if version := binary.BigEndian.Uint32(header[len(Magic):]); version != FormatVersion {
+		return false, fmt.Errorf("%w %d in %s", ErrUnknownVersion, version, f.File.Name())
+	}
+	return false, nil
+}
+
+// 将旧格式的文件转换为当前格式。末尾未写完的记录被丢弃。
+func upgradeLegacy(path_file string) error {
+	data, err := os.ReadFile(path_file)
+	if err != nil {
+		return err
+	}
+	tmp_path := path_file + ".upgrade"
+	_ = os.Remove(tmp_path)
+	tmp, err := new(tmp_path)
+	if err != nil {
+		return err
+	}
+	var offset, entries int
+	for offset+legacyHeaderSize <= len(data) {
+		keySize := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		valueSize := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		end := offset + legacyHeaderSize + keySize + valueSize
+		if keySize < 0 || valueSize < 0 || end > len(data) {
+			break
+		}
+		key := data[offset+legacyHeaderSize : offset+legacyHeaderSize+keySize]
+		value := data[offset+legacyHeaderSize+keySize : end]
+		mark := binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		timestamp := binary.BigEndian.Uint64(data[offset+12 : offset+legacyHeaderSize])
+		if _, err := tmp.Write(NewEntry(key, value, mark, timestamp)); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		offset = end
+		entries++
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	fmt.Println("upgrade legacy data file ", path_file, " entries: ", entries, " discarded bytes: ", len(data)-offset)
+	return os.Rename(tmp_path, path_file)
 }
 
 func NewDataFile(path, fileName string) (*DatabaseFile, error) {
@@ -121,11 +243,6 @@ func NewDataFile(path, fileName string) (*DatabaseFile, error) {
 	return new(path_file)
 }
 
-func NewMergeFile(path string) (*DatabaseFile, error) {
-	path_file := filepath.Join(path, MergeFileName)
-	return new(path_file)
-}
-
 func (f *DatabaseFile) Write(entry *Entry) (int64, error) { // 返回entry对应的写入偏移量
 	data := entry.Encode()
 	f.mutex.Lock()
@@ -153,18 +270,19 @@ func (f *DatabaseFile) Read(offset int64) (*Entry, error) {
 	if err != nil {
 		return nil, err
 	}
-	key := make([]byte, entry.KeySize)
-	_, err = f.File.ReadAt(key, offset+HeaderSize)
-	if err != nil {
-		return nil, err
+	if offset+int64(entry.Size()) > f.GetOffset() { // 记录没有写完，或头部已损坏
+		return nil, io.ErrUnexpectedEOF
 	}
-	value := make([]byte, entry.ValueSize)
-	_, err = f.File.ReadAt(value, offset+int64(HeaderSize+entry.KeySize)) // 从偏移量开始读取entry.ValueSize个字节
+	data := make([]byte, entry.Size())
+	_, err = f.File.ReadAt(data, offset)
 	if err != nil {
 		return nil, err
 	}
-	entry.Key = key
-	entry.Value = value
+	if err := entry.verify(data); err != nil {
+		return nil, fmt.Errorf("%w at offset %d", err, offset)
+	}
+	entry.Key = data[HeaderSize : HeaderSize+entry.KeySize : HeaderSize+entry.KeySize]
+	entry.Value = data[HeaderSize+entry.KeySize:]
 	return entry, nil
 }
 
@@ -188,40 +306,141 @@ func (f *DatabaseFile) GetOffset() int64 {
 	return atomic.LoadInt64(&f.offset)
 }
 
-// 将数据顺序写入到磁盘的操作封装
+// 将数据顺序写入到磁盘的操作封装，数据目录的布局见segment.go。
 type WriteSequence struct {
-	index        sync.Map      // 索引，string key -> int64 offset
-	dataPath     string        // 数据文件路径
-	databaseFile *DatabaseFile // 数据文件
+	index        *sync.Map                // 索引，string key -> Location
+	dataPath     string                   // 数据目录
+	segments     map[uint32]*DatabaseFile // 全部数据段，包括活跃段
+	databaseFile *DatabaseFile            // 活跃段，新的记录追加到这里
+	activeID     uint32                   // 活跃段的编号
+	activeHints  []hintRecord             // 活跃段中已写入的记录，封存时写入提示文件
+	segmentSize  int64                    // 数据段的大小上限
 	mutex        sync.RWMutex
+	writeTime    *metrics.Histogram // Put和Delete的耗时
+	mergeTime    *metrics.Histogram // Merge和后台压缩的耗时
+	recovery     Recovery           // 打开数据目录时丢弃的损坏记录
+	fsync        FsyncPolicy        // fsync策略
+	dirty        int32              // FsyncInterval时，上次fsync之后是否有新的写入
+	stop         chan struct{}      // Close时关闭，停止后台fsync和压缩
+	closeOnce    sync.Once          // 保证重复调用Close时只关闭一次
+	closeErr     error              // 第一次Close的结果
+
+	live         map[uint32]int64 // 各数据段中有效记录占用的字节数
+	compactMu    sync.Mutex       // 保证同一时刻只有一次压缩（包括Merge）
+	compactRatio float64          // 数据段中无效数据的比例达到该值时压缩，小于0时不按比例压缩
+	compactBytes int64            // 无效数据合计达到该值时压缩，小于0时不按大小压缩
+	compactions  int64            // 已完成压缩的数据段数量
+}
+
+/*
+Recovery 记录打开数据目录时发现的损坏记录。
+TruncatedBytes和DiscardedEntries是从数据段末尾截断的字节数，以及按记录头部估算的被丢弃的记录数；
+SkippedBytes和SkippedEntries是被跳过、仍保留在数据段中的损坏记录：之后还有完好记录的记录，以及已封存数据段末尾的记录。
+*/
+type Recovery struct {
+	TruncatedBytes   int64
+	DiscardedEntries int64
+	SkippedBytes     int64
+	SkippedEntries   int64
 }
 
+/*
+按编号顺序加载全部数据段，重建索引。有提示文件的数据段只读取提示文件；
+其他数据段需要扫描，除最后一个数据段外，扫描后为它补写提示文件。
+最后一个数据段未满时继续作为活跃段写入，否则新建一个数据段。
+*/
 func (w *WriteSequence) loadIndex() error {
-	if w.databaseFile == nil {
-		return errors.New("database file is nil")
+	ids, err := listSegments(w.dataPath)
+	if err != nil {
+		return err
 	}
-	var offset int64 = 0
-	for !w.databaseFile.IsOffsetEqual(offset) {
-		entry, err := w.databaseFile.Read(offset)
+	if len(ids) == 0 {
+		legacy := filepath.Join(w.dataPath, DataFileName)
+		if _, err := os.Stat(legacy); err == nil { // 旧版本的单个数据文件作为第一个数据段
+			if err := os.Rename(legacy, filepath.Join(w.dataPath, segmentName(1))); err != nil {
+				return err
+			}
+			ids = []uint32{1}
+		}
+	}
+	if len(ids) == 0 {
+		return w.newActive(1)
+	}
+	var lastHints []hintRecord
+	lastHinted := false
+	for i, id := range ids {
+		f, err := NewDataFile(w.dataPath, segmentName(id))
 		if err != nil {
-			if err == io.EOF {
-				break
+			return err
+		}
+		w.segments[id] = f
+		records, err := w.readHints(id)
+		hinted := err == nil
+		if !hinted {
+			if !os.IsNotExist(err) {
+				fmt.Println("hint file of segment ", id, " is unusable, scan the segment: ", err)
+			}
+			if records, err = w.scanSegment(id, f, i == len(ids)-1); err != nil {
+				return err
+			}
+			if i < len(ids)-1 {
+				if err := w.writeHints(id, records); err != nil {
+					return err
+				}
 			}
+		}
+		w.apply(records)
+		lastHints, lastHinted = records, hinted
+	}
+	last := ids[len(ids)-1]
+	if w.segments[last].GetOffset() < w.segmentSize {
+		// 继续写入最后一个数据段，它的提示文件在再次封存时重新生成。
+		w.databaseFile, w.activeID, w.activeHints = w.segments[last], last, lastHints
+		if err := os.Remove(filepath.Join(w.dataPath, hintName(last))); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		fmt.Println("load index offset : ", offset, string(entry.Key), entry.Mark)
-		if entry.Mark == DEL {
-			w.index.Delete(string(entry.Key))
-		} else {
-			w.index.Store(string(entry.Key), offset)
+		return nil
+	}
+	if !lastHinted {
+		if err := w.writeHints(last, lastHints); err != nil {
+			return err
 		}
-		offset += int64(entry.Size())
 	}
-	return nil
+	return w.newActive(last + 1)
+}
+
+// Recovery 返回打开数据目录时截断或跳过的损坏记录，没有损坏记录时为零值。
+func (w *WriteSequence) Recovery() Recovery {
+	return w.recovery
 }
 
 func NewWriteSequence(dir_path, backup_file string) (*WriteSequence, error) {
-	_, err := os.Stat(dir_path)
+	return NewWriteSequenceOpts(dir_path, backup_file, nil)
+}
+
+// NewWriteSequenceOpts 与 NewWriteSequence 相同，但可以通过o指定fsync策略和数据段大小，o为nil时全部使用默认值。
+func NewWriteSequenceOpts(dir_path, backup_file string, o *Options) (*WriteSequence, error) {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	fsync, err := opts.Fsync.validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.CompactRatio == 0 {
+		opts.CompactRatio = DefaultCompactRatio
+	}
+	if opts.CompactBytes == 0 {
+		opts.CompactBytes = DefaultCompactBytes
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = DefaultCompactInterval
+	}
+	_, err = os.Stat(dir_path)
 	if os.IsNotExist(err) {
 		err = os.MkdirAll(dir_path, os.ModePerm)
 	}
@@ -233,94 +452,209 @@ func NewWriteSequence(dir_path, backup_file string) (*WriteSequence, error) {
 		return nil, err
 	}
 	if len(backup_file) != 0 {
-		data_file_abs := filepath.Join(dir_path_abs, DataFileName)
-		backup_file_abs, err := filepath.Abs(backup_file)
-		if err != nil {
+		if err := restore(dir_path_abs, backup_file); err != nil {
 			return nil, err
 		}
-		fmt.Println("data_file_abs ", data_file_abs, " backup_file_abs ", backup_file_abs)
-		if data_file_abs != backup_file_abs {
-			file1, err1 := os.Open(backup_file_abs) // 打开备份文件
-			if err1 != nil {
-				return nil, err1
-			}
-			if _, err := os.Stat(data_file_abs); !os.IsNotExist(err) { // 目标文件已存在，则重命名
-				timestamp := time.Now().UnixMilli()
-				new_file_path := fmt.Sprintf("%s.temp.%d", data_file_abs, timestamp)
-				if err := os.Rename(data_file_abs, new_file_path); err != nil {
-					return nil, err
-				}
-			}
-			file2, err2 := os.OpenFile(data_file_abs, os.O_RDWR|os.O_CREATE, os.ModePerm) // 创建目标文件
-			if err2 != nil {
-				return nil, err2
-			}
-			// 使用结束关闭文件
-			defer file1.Close()
-			defer file2.Close()
-			_, err := io.Copy(file2, file1)
-			if err != nil {
-				return nil, err
-			}
-		}
-	}
-	df, err := NewDataFile(dir_path_abs, "")
-	if err != nil {
-		return nil, err
 	}
 	w := &WriteSequence{
-		index:        sync.Map{},
+		index:        &sync.Map{},
 		dataPath:     dir_path_abs,
-		databaseFile: df,
+		segments:     make(map[uint32]*DatabaseFile),
+		segmentSize:  opts.SegmentSize,
 		mutex:        sync.RWMutex{},
+		writeTime:    metrics.NewHistogram(writeBuckets),
+		mergeTime:    metrics.NewHistogram(nil),
+		fsync:        fsync,
+		stop:         make(chan struct{}),
+		live:         make(map[uint32]int64),
+		compactRatio: opts.CompactRatio,
+		compactBytes: opts.CompactBytes,
 	}
 
 	err = w.loadIndex()
 	if err != nil {
+		w.closeSegments()
 		return nil, err
 	}
+	if fsync == FsyncInterval {
+		interval := opts.FsyncInterval
+		if interval <= 0 {
+			interval = DefaultFsyncInterval
+		}
+		go w.syncLoop(interval)
+	}
+	if opts.CompactInterval > 0 {
+		go w.compactLoop(opts.CompactInterval)
+	}
 	return w, nil
 }
 
+/*
+从备份文件恢复：目录中原有的数据段和提示文件被重命名（加上.temp.{时间戳}后缀）保留，
+备份文件被复制为第一个数据段。备份文件本身就是目录中的数据段时不做任何处理。
+*/
+func restore(dir_path_abs, backup_file string) error {
+	backup_file_abs, err := filepath.Abs(backup_file)
+	if err != nil {
+		return err
+	}
+	ids, err := listSegments(dir_path_abs)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	for _, id := range ids {
+		if filepath.Join(dir_path_abs, segmentName(id)) == backup_file_abs {
+			return nil
+		}
+		existing = append(existing, segmentName(id), hintName(id))
+	}
+	fmt.Println("restore ", dir_path_abs, " from backup_file_abs ", backup_file_abs)
+	file1, err := os.Open(backup_file_abs) // 打开备份文件
+	if err != nil {
+		return err
+	}
+	defer file1.Close()
+	timestamp := time.Now().UnixMilli()
+	for _, name := range append(existing, DataFileName) {
+		path := filepath.Join(dir_path_abs, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path, fmt.Sprintf("%s.temp.%d", path, timestamp)); err != nil { // 目标文件已存在，则重命名
+			return err
+		}
+	}
+	file2, err := os.OpenFile(filepath.Join(dir_path_abs, segmentName(1)), os.O_RDWR|os.O_CREATE, 0644) // 创建目标文件
+	if err != nil {
+		return err
+	}
+	defer file2.Close()
+	_, err = io.Copy(file2, file1)
+	return err
+}
+
+// 新建编号为id的数据段作为活跃段。调用者需持有w.mutex。
+func (w *WriteSequence) newActive(id uint32) error {
+	f, err := NewDataFile(w.dataPath, segmentName(id))
+	if err != nil {
+		return err
+	}
+	if w.fsync != FsyncNo {
+		if err := syncDir(w.dataPath); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	w.segments[id] = f
+	w.databaseFile, w.activeID, w.activeHints = f, id, nil
+	return nil
+}
+
+// 封存活跃段：按fsync策略落盘，并写入它的提示文件。调用者需持有w.mutex。
+func (w *WriteSequence) seal() error {
+	if w.fsync != FsyncNo {
+		if err := w.databaseFile.Sync(); err != nil {
+			return err
+		}
+	}
+	return w.writeHints(w.activeID, w.activeHints)
+}
+
+// 封存活跃段，之后的写入进入新的数据段。调用者需持有w.mutex。
+func (w *WriteSequence) rotate() error {
+	if err := w.seal(); err != nil {
+		return err
+	}
+	return w.newActive(w.activeID + 1)
+}
+
+// 将记录追加到活跃段，活跃段写满时先切换到新的数据段。调用者需持有w.mutex。
+func (w *WriteSequence) write(entry *Entry) (Location, error) {
+	if size := w.databaseFile.GetOffset(); size > FileHeaderSize && size+int64(entry.Size()) > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return Location{}, err
+		}
+	}
+	offset, err := w.databaseFile.Write(entry)
+	if err != nil {
+		return Location{}, err
+	}
+	loc := Location{Segment: w.activeID, Offset: offset, Size: uint32(entry.Size()), Timestamp: entry.Timestamp}
+	w.activeHints = append(w.activeHints, hintRecord{key: string(entry.Key), mark: entry.Mark, loc: loc})
+	return loc, w.afterWrite()
+}
+
+// 写入单条记录通常远快于1ms，因此使用更细的直方图上界（单位：秒）。
+var writeBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1}
+
+// WriteDuration 返回Put和Delete耗时（秒）的直方图。
+func (w *WriteSequence) WriteDuration() *metrics.Histogram {
+	return w.writeTime
+}
+
+// MergeDuration 返回Merge和后台压缩耗时（秒）的直方图。
+func (w *WriteSequence) MergeDuration() *metrics.Histogram {
+	return w.mergeTime
+}
+
 func (w *WriteSequence) Put(key, value []byte) error {
+	return w.PutWithTimestamp(key, value, 0)
+}
+
+// PutWithTimestamp 与Put相同，但使用指定的毫秒时间戳，例如从其他副本复制来的数据的版本。timestamp为0时使用当前时间。
+func (w *WriteSequence) PutWithTimestamp(key, value []byte, timestamp uint64) error {
 	now := time.Now()
-	timestamp := now.UnixMilli() // 毫秒时间戳
+	defer w.writeTime.ObserveSince(now)
+	if timestamp == 0 {
+		timestamp = uint64(now.UnixMilli()) // 毫秒时间戳
+	}
 	fmt.Println(w.dataPath, " Put() ", key)
-	entry := NewEntry(key, value, PUT, uint64(timestamp))
+	entry := NewEntry(key, value, PUT, timestamp)
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	offset, err := w.databaseFile.Write(entry)
+	loc, err := w.write(entry)
 	if err != nil {
 		return err
 	}
-	w.index.Store(string(key), offset)
+	w.setLocation(string(key), loc)
 	return nil
 }
 
-func (w *WriteSequence) IsKeyExist(key []byte) (int64, bool) {
-	offset, exist := w.index.Load(string(key))
+// IsKeyExist 返回key的最新记录的位置。
+func (w *WriteSequence) IsKeyExist(key []byte) (Location, bool) {
+	loc, exist := w.index.Load(string(key))
 	if !exist {
-		return 0, exist
+		return Location{}, exist
 	}
-	offset_int64, ok := offset.(int64)
-	return offset_int64, ok
+	loc_value, ok := loc.(Location)
+	return loc_value, ok
 }
 
 func (w *WriteSequence) Get(key []byte) ([]byte, error) {
+	entry, err := w.GetEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// GetEntry 返回key对应的完整记录，包括写入时的时间戳。
+func (w *WriteSequence) GetEntry(key []byte) (*Entry, error) {
 	if len(key) == 0 {
 		return nil, errors.New("key is nil")
 	}
 	w.mutex.RLock()
 	defer w.mutex.RUnlock()
-	offset, exist := w.IsKeyExist(key)
+	loc, exist := w.IsKeyExist(key)
 	if !exist {
 		return nil, errors.New("key not exist")
 	}
-	entry, err := w.databaseFile.Read(offset)
-	if err != nil {
-		return nil, err
+	f, ok := w.segments[loc.Segment]
+	if !ok {
+		return nil, fmt.Errorf("segment %d not exist", loc.Segment)
 	}
-	return entry.Value, nil
+	return f.Read(loc.Offset)
 }
 
 func (w *WriteSequence) Delete(key []byte) error {
@@ -334,94 +668,57 @@ func (w *WriteSequence) Delete(key []byte) error {
 		return nil
 	}
 	now := time.Now()
+	defer w.writeTime.ObserveSince(now)
 	timestamp := now.UnixMilli() // 毫秒时间戳
 	entry := NewEntry(key, nil, DEL, uint64(timestamp))
-	_, err := w.databaseFile.Write(entry)
+	_, err := w.write(entry)
 	if err != nil {
 		return err
 	}
-	w.index.Delete(string(key))
+	w.removeLocation(string(key))
 	return nil
 }
 
-func (w *WriteSequence) Merge() error {
-	if w.databaseFile.IsOffsetEqual(0) {
-		return nil
+/*
+Backup 将全部有效的记录写入一个备份文件，格式与数据段相同，可以通过NewWriteSequence的backup_file恢复。
+backupFileName为空时，在数据目录中以append.data.{毫秒时间戳}命名。备份期间阻塞写入，不阻塞读取。
+*/
+func (w *WriteSequence) Backup(backupFileName string) error {
+	if len(backupFileName) == 0 {
+		timestamp := time.Now().UnixMilli() // 获取当前时间戳（毫秒）
+		timestampStr := fmt.Sprintf("%s.%d", DataFileName, timestamp)
+		backupFileName = filepath.Join(w.dataPath, timestampStr)
 	}
-	merge_file, err := NewMergeFile(w.dataPath)
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if err := os.Remove(backupFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	backupFile, err := new(backupFileName) // 创建目标文件
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = os.Remove(merge_file.File.Name())
-	}()
-	err = nil
-	new_index := sync.Map{} // 索引，string key -> int64 offset
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	w.index.Range(func(k, v any) bool {
-		key, ok1 := k.(string)
-		value, ok2 := v.(int64)
-		if !ok1 || !ok2 {
-			err = errors.New("type assert error")
+	defer backupFile.Close() // 使用结束关闭文件
+	w.index.Range(func(_, v any) bool {
+		loc := v.(Location)
+		entry, readErr := w.segments[loc.Segment].Read(loc.Offset)
+		if readErr != nil {
+			err = fmt.Errorf("read entry error: %w", readErr)
 			return false
 		}
-		entry, err := w.databaseFile.Read(value)
-		if err != nil {
-			err = fmt.Errorf("read entry error: %w", err)
+		if _, writeErr := backupFile.Write(entry); writeErr != nil {
+			err = fmt.Errorf("write backup file error: %w", writeErr)
 			return false
 		}
-		new_offset, err := merge_file.Write(entry)
-		if err != nil {
-			err = fmt.Errorf("write merge file error: %w", err)
-			return false
-		}
-		new_index.Store(key, new_offset)
 		return true
 	})
 	if err != nil {
 		return err
 	}
-	_ = w.databaseFile.Close()
-
-	backup_file := filepath.Join(w.dataPath, DataBackupFileName)
-	_ = os.Rename(w.databaseFile.File.Name(), backup_file) // 备份旧文件
-
-	_ = merge_file.File.Close()
-
-	_ = os.Rename(merge_file.File.Name(), w.databaseFile.File.Name())
-
-	new_file, err := NewDataFile(w.dataPath, "")
-	if err != nil {
-		_ = os.Rename(backup_file, w.databaseFile.File.Name()) // 恢复备份文件
-		return err
-	}
-
-	w.databaseFile = new_file
-	w.index = new_index
-	_ = os.Remove(backup_file) // 删除文件
-
-	return nil
-}
-
-func (w *WriteSequence) Backup(backupFileName string) error {
-	if len(backupFileName) == 0 {
-		timestamp := time.Now().UnixMilli() // 获取当前时间戳（毫秒）
-		timestampStr := fmt.Sprintf("%s.%d", DataFileName, timestamp)
-		backupFileName = filepath.Join(w.dataPath, timestampStr)
-	}
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	backupFile, err := os.OpenFile(backupFileName, os.O_RDWR|os.O_CREATE, os.ModePerm) // 创建目标文件
-	if err != nil {
-		return err
-	}
-	defer backupFile.Close() // 使用结束关闭文件
-	n, err := io.Copy(backupFile, w.databaseFile.File)
-	if err != nil {
+	if err := w.syncFile(backupFile.File); err != nil {
 		return err
 	}
-	fmt.Println(backupFileName, "backup success, size:", n)
+	fmt.Println(backupFileName, "backup success, size:", backupFile.GetOffset())
 	return nil
 }
 
@@ -446,7 +743,32 @@ func (w *WriteSequence) GetAllIndexKeys() []string {
 	return result
 }
 
+// Close 停止后台fsync，封存活跃段（写入提示文件，下次启动时不需要扫描），然后关闭全部数据段。多次调用时返回第一次的结果。
 func (w *WriteSequence) Close() error {
+	w.closeOnce.Do(func() {
+		w.closeErr = w.close()
+	})
+	return w.closeErr
+}
+
+func (w *WriteSequence) close() error {
 	fmt.Println("close write sequence")
-	return w.databaseFile.Close()
+	close(w.stop)
+	w.compactMu.Lock() // 等待正在进行的压缩停止
+	defer w.compactMu.Unlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	if !w.databaseFile.IsOffsetEqual(FileHeaderSize) {
+		err = w.seal()
+	}
+	return errors.Join(err, w.closeSegments())
+}
+
+func (w *WriteSequence) closeSegments() error {
+	var errs []error
+	for _, f := range w.segments {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
 }
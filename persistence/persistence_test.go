@@ -0,0 +1,429 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openWithKeys(t *testing.T, dir string, keys ...string) *WriteSequence {
+	t.Helper()
+	w, err := NewWriteSequence(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := w.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
+
+func checkKeys(t *testing.T, w *WriteSequence, present, missing []string) {
+	t.Helper()
+	for _, key := range present {
+		if v, err := w.Get([]byte(key)); err != nil || string(v) != "v-"+key {
+			t.Errorf("get %s = %q, %v; want v-%s", key, v, err, key)
+		}
+	}
+	for _, key := range missing {
+		if _, err := w.Get([]byte(key)); err == nil {
+			t.Errorf("get %s succeeded, want it discarded", key)
+		}
+	}
+}
+
+// 模拟崩溃：不封存活跃段，直接关闭文件，活跃段没有提示文件。
+func crash(w *WriteSequence) {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		w.compactMu.Lock()
+		defer w.compactMu.Unlock()
+		w.closeSegments()
+	})
+}
+
+func TestTornTailRecovery(t *testing.T) {
+	dir := t.TempDir()
+	w := openWithKeys(t, dir, "a", "b", "c")
+	crash(w)
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil { // 最后一条记录只写了一部分
+		t.Fatal(err)
+	}
+
+	w = openWithKeys(t, dir)
+	checkKeys(t, w, []string{"a", "b"}, []string{"c"})
+	if r := w.Recovery(); r.DiscardedEntries != 1 || r.TruncatedBytes != HeaderSize+int64(len("c")+len("v-c"))-3 {
+		t.Fatalf("recovery = %+v, want one partial entry discarded", r)
+	}
+	if err := w.Put([]byte("d"), []byte("v-d")); err != nil {
+		t.Fatal(err)
+	}
+	crash(w)
+
+	w = openWithKeys(t, dir)
+	defer w.Close()
+	checkKeys(t, w, []string{"a", "b", "d"}, []string{"c"})
+	if r := w.Recovery(); r != (Recovery{}) {
+		t.Fatalf("recovery = %+v after a clean reopen, want nothing discarded", r)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	w := openWithKeys(t, dir, "a", "b", "c")
+	loc, _ := w.IsKeyExist([]byte("b"))
+	offset := loc.Offset
+	crash(w)
+	path := filepath.Join(dir, segmentName(1))
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("X"), offset+HeaderSize+1); err != nil { // 修改b的value
+		t.Fatal(err)
+	}
+	f.Close()
+
+	df, err := NewDataFile(dir, segmentName(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.Read(offset); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("read corrupt entry = %v, want ErrChecksum", err)
+	}
+	df.Close()
+
+	// b之后还有完好的c：只跳过b，不截断数据段。
+	w = openWithKeys(t, dir)
+	checkKeys(t, w, []string{"a", "c"}, []string{"b"})
+	if r := w.Recovery(); r != (Recovery{SkippedBytes: HeaderSize + 1 + 3, SkippedEntries: 1}) {
+		t.Fatalf("recovery = %+v, want only b skipped", r)
+	}
+	crash(w)
+
+	// 损坏的记录之后没有完好的记录时，从它开始截断。
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("X"), offset+(HeaderSize+1+3)+HeaderSize+1); err != nil { // 修改c的value
+		t.Fatal(err)
+	}
+	f.Close()
+	w = openWithKeys(t, dir)
+	defer w.Close()
+	checkKeys(t, w, []string{"a"}, []string{"b", "c"})
+	if r := w.Recovery(); r.DiscardedEntries != 2 || r.TruncatedBytes != 2*(HeaderSize+1+3) {
+		t.Fatalf("recovery = %+v, want b and c discarded", r)
+	}
+}
+
+func TestUpgradeLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	var data []byte
+	for _, key := range []string{"a", "b"} {
+		value := "v-" + key
+		header := make([]byte, legacyHeaderSize)
+		binary.BigEndian.PutUint32(header[:4], uint32(len(key)))
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(value)))
+		binary.BigEndian.PutUint32(header[8:12], PUT)
+		binary.BigEndian.PutUint64(header[12:], 42)
+		data = append(append(append(data, header...), key...), value...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, DataFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := openWithKeys(t, dir, "c")
+	defer w.Close()
+	checkKeys(t, w, []string{"a", "b", "c"}, nil)
+	if entry, err := w.GetEntry([]byte("a")); err != nil || entry.Timestamp != 42 {
+		t.Fatalf("entry a = %+v, %v; want the legacy timestamp kept", entry, err)
+	}
+}
+
+func TestFsyncPolicy(t *testing.T) {
+	if _, err := NewWriteSequenceOpts(t.TempDir(), "", &Options{Fsync: "sometimes"}); err == nil {
+		t.Fatal("unknown fsync policy accepted")
+	}
+
+	dir := t.TempDir()
+	w, err := NewWriteSequenceOpts(dir, "", &Options{Fsync: FsyncInterval, FsyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put([]byte("a"), []byte("v-a")); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&w.dirty) != 1 {
+		t.Fatal("put did not mark the file for the background fsync")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&w.dirty) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background fsync did not run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 每次备份都包含完整的数据文件。
+	for i := 0; i < 2; i++ {
+		name := filepath.Join(t.TempDir(), "backup")
+		if err := w.Backup(name); err != nil {
+			t.Fatal(err)
+		}
+		backup, err := NewWriteSequenceOpts(t.TempDir(), name, &Options{Fsync: FsyncAlways})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, backup, []string{"a"}, nil)
+		if err := backup.Delete([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		backup.Close()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 重复调用Close不会panic，返回第一次的结果。
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSegmentsAndHints(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{SegmentSize: 100} // 每个数据段只能容纳两三条记录
+	w, err := NewWriteSequenceOpts(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := string(rune('a' + i))
+		keys = append(keys, key)
+		if err := w.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := listSegments(dir)
+	if len(ids) < 4 {
+		t.Fatalf("segments = %v, want the log rotated into several segments", ids)
+	}
+	for _, id := range ids {
+		if _, err := os.Stat(filepath.Join(dir, hintName(id))); err != nil {
+			t.Fatalf("segment %d has no hint file after Close: %v", id, err)
+		}
+	}
+
+	// 破坏第一个数据段中的value：索引只从提示文件重建，不扫描数据段，因此不会截断它。
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(ids[0])), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("XX"), FileHeaderSize+HeaderSize+2); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	w, err = NewWriteSequenceOpts(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := w.GetIndexSize(); n != 9 || w.Recovery() != (Recovery{}) {
+		t.Fatalf("index size = %d, recovery = %+v; want 9 keys loaded from hint files", n, w.Recovery())
+	}
+	checkKeys(t, w, keys[2:], []string{"a"})
+
+	// Merge只保留有效的记录，并删除旧的数据段。
+	if err := w.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, w, keys[2:], []string{"a"})
+	if _, err := os.Stat(filepath.Join(dir, segmentName(ids[0]))); !os.IsNotExist(err) {
+		t.Fatalf("old segment %d still exists after merge: %v", ids[0], err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w = openWithKeys(t, dir)
+	defer w.Close()
+	checkKeys(t, w, keys[2:], []string{"a"})
+}
+
+// 已封存的数据段缺少提示文件时需要扫描，其中的损坏记录被跳过，数据段不会被截断。
+func TestSealedSegmentNotTruncated(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{SegmentSize: 100}
+	w, err := NewWriteSequenceOpts(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	for _, key := range keys {
+		if err := w.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var first []string // 第一个数据段中的key
+	for _, key := range keys {
+		if loc, _ := w.IsKeyExist([]byte(key)); loc.Segment == 1 {
+			first = append(first, key)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := listSegments(dir); len(ids) < 2 || len(first) < 2 {
+		t.Fatalf("segments = %v, keys in the first segment = %v; want several segments", ids, first)
+	}
+	if err := os.Remove(filepath.Join(dir, hintName(1))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("X"), info.Size()-1); err != nil { // 破坏第一个数据段最后一条记录的value
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w, err = NewWriteSequenceOpts(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	last := first[len(first)-1]
+	checkKeys(t, w, append(first[:len(first)-1:len(first)-1], keys[len(first):]...), []string{last})
+	if r := w.Recovery(); r != (Recovery{SkippedBytes: HeaderSize + 1 + 3, SkippedEntries: 1}) {
+		t.Fatalf("recovery = %+v, want the corrupt record skipped", r)
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Fatalf("sealed segment truncated from %d to %d bytes", info.Size(), after.Size())
+	}
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{SegmentSize: 100, CompactRatio: 0.5, CompactBytes: -1, CompactInterval: 5 * time.Millisecond}
+	w, err := NewWriteSequenceOpts(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a和b在第一个数据段中，之后反复覆盖c，使它所在的数据段几乎全是无效数据。
+	for _, key := range []string{"a", "b"} {
+		if err := w.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := w.Put([]byte("c"), []byte("v-c")); err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, w, []string{"b", "c"}, []string{"a"}) // 压缩期间读写不受影响
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		usage := w.DiskUsage()
+		if usage.Compactions > 0 && usage.Segments < 5 {
+			if usage.LiveBytes != 2*(HeaderSize+1+3) {
+				t.Fatalf("usage = %+v, want b and c live", usage)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage = %+v, want garbage segments compacted", usage)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkKeys(t, w, []string{"b", "c"}, []string{"a"})
+	crash(w)
+
+	// 压缩后的数据目录可以正常重建索引，被删除的a不会因为删除标记被丢弃而恢复。
+	w = openWithKeys(t, dir)
+	defer w.Close()
+	checkKeys(t, w, []string{"b", "c"}, []string{"a"})
+}
+
+// 压缩时，只有编号更小的数据段中还有被删除的key的PUT记录时才保留删除标记。
+func TestCompactTombstones(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriteSequenceOpts(dir, "", &Options{CompactInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotate := func() uint32 {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if err := w.rotate(); err != nil {
+			t.Fatal(err)
+		}
+		return w.activeID - 1
+	}
+	compact := func(id uint32) {
+		w.compactMu.Lock()
+		defer w.compactMu.Unlock()
+		if err := w.compactSegment(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activeSize := func() int64 {
+		w.mutex.RLock()
+		defer w.mutex.RUnlock()
+		return w.databaseFile.GetOffset() - FileHeaderSize
+	}
+
+	// b所在的数据段更旧，但其中没有a的记录，a的删除标记可以丢弃。
+	if err := w.Put([]byte("b"), []byte("v-b")); err != nil {
+		t.Fatal(err)
+	}
+	rotate()
+	if err := w.Put([]byte("a"), []byte("v-a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	compact(rotate())
+	if size := activeSize(); size != 0 {
+		t.Fatalf("active segment has %d bytes, want the tombstone of a dropped", size)
+	}
+
+	// 更旧的数据段中还有c的值，c的删除标记必须保留，否则重建索引时c会复活。
+	if err := w.Put([]byte("c"), []byte("v-c")); err != nil {
+		t.Fatal(err)
+	}
+	rotate()
+	if err := w.Delete([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	compact(rotate())
+	if size := activeSize(); size != HeaderSize+1 {
+		t.Fatalf("active segment has %d bytes, want the tombstone of c kept", size)
+	}
+	checkKeys(t, w, []string{"b"}, []string{"a", "c"})
+	crash(w)
+
+	w = openWithKeys(t, dir)
+	defer w.Close()
+	checkKeys(t, w, []string{"b"}, []string{"a", "c"})
+}
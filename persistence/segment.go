@@ -0,0 +1,296 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+数据目录采用Bitcask的布局：数据被追加写入编号递增的数据段（000000001.data、000000002.data……），
+当前写入的数据段（活跃段）超过SegmentSize后被封存，不再修改，之后的写入进入编号加一的新数据段。
+封存数据段时，为它写入一个提示文件（000000001.hint），按写入顺序记录其中每条记录的
+key→(数据段, 偏移量, 大小, 时间戳)以及PUT/DEL标志，但不包含value。
+启动时按编号顺序读取提示文件即可重建索引，不需要读取数据段中的value；
+只有缺少提示文件或提示文件损坏的数据段（例如崩溃前的活跃段）才需要扫描数据段本身。
+*/
+const (
+	segmentExt = ".data"
+	hintExt    = ".hint"
+
+	DefaultSegmentSize = 64 << 20
+
+	hintHeaderSize = 36 // CRC、KeySize、Mark、Segment、Size（均为uint32），Offset、Timestamp（均为uint64）
+)
+
+// HintMagic 是提示文件开头的魔数，之后是格式版本FormatVersion。
+var HintMagic = []byte("MYCH")
+
+// Location 是key的最新记录在数据目录中的位置。
+type Location struct {
+	Segment   uint32 // 数据段编号
+	Offset    int64  // 记录在数据段中的偏移量
+	Size      uint32 // 记录的大小，包括头部
+	Timestamp uint64 // 记录的毫秒时间戳
+}
+
+// 提示文件中的一条记录，对应数据段中的一条记录。
+type hintRecord struct {
+	key  string
+	mark uint32
+	loc  Location
+}
+
+func segmentName(id uint32) string {
+	return fmt.Sprintf("%09d%s", id, segmentExt)
+}
+
+func hintName(id uint32) string {
+	return fmt.Sprintf("%09d%s", id, hintExt)
+}
+
+// 按编号从小到大返回目录中的全部数据段。
+func listSegments(dir string) ([]uint32, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentExt) || len(name) != 9+len(segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if err != nil || id == 0 {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func encodeHints(records []hintRecord) []byte {
+	size := FileHeaderSize
+	for _, r := range records {
+		size += hintHeaderSize + len(r.key)
+	}
+	buf := make([]byte, FileHeaderSize, size)
+	copy(buf, HintMagic)
+	binary.BigEndian.PutUint32(buf[len(HintMagic):], FormatVersion)
+	for _, r := range records {
+		rec := make([]byte, hintHeaderSize+len(r.key))
+		binary.BigEndian.PutUint32(rec[4:8], uint32(len(r.key)))
+		binary.BigEndian.PutUint32(rec[8:12], r.mark)
+		binary.BigEndian.PutUint32(rec[12:16], r.loc.Segment)
+		binary.BigEndian.PutUint32(rec[16:20], r.loc.Size)
+		binary.BigEndian.PutUint64(rec[20:28], uint64(r.loc.Offset))
+		binary.BigEndian.PutUint64(rec[28:36], r.loc.Timestamp)
+		copy(rec[hintHeaderSize:], r.key)
+		binary.BigEndian.PutUint32(rec[:4], crc32.Checksum(rec[4:], crcTable))
+		buf = append(buf, rec...)
+	}
+	return buf
+}
+
+func decodeHints(data []byte) ([]hintRecord, error) {
+	if len(data) < FileHeaderSize || !bytes.Equal(data[:len(HintMagic)], HintMagic) {
+		return nil, errors.New("invalid hint file header")
+	}
+	if version := binary.BigEndian.Uint32(data[len(HintMagic):FileHeaderSize]); version != FormatVersion {
+		return nil, fmt.Errorf("%w %d in hint file", ErrUnknownVersion, version)
+	}
+	var records []hintRecord
+	for pos := FileHeaderSize; pos < len(data); {
+		if pos+hintHeaderSize > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		keySize := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + hintHeaderSize + keySize
+		if keySize < 0 || end > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if crc32.Checksum(data[pos+4:end], crcTable) != binary.BigEndian.Uint32(data[pos:pos+4]) {
+			return nil, ErrChecksum
+		}
+		records = append(records, hintRecord{
+			key:  string(data[pos+hintHeaderSize : end]),
+			mark: binary.BigEndian.Uint32(data[pos+8 : pos+12]),
+			loc: Location{
+				Segment:   binary.BigEndian.Uint32(data[pos+12 : pos+16]),
+				Size:      binary.BigEndian.Uint32(data[pos+16 : pos+20]),
+				Offset:    int64(binary.BigEndian.Uint64(data[pos+20 : pos+28])),
+				Timestamp: binary.BigEndian.Uint64(data[pos+28 : pos+36]),
+			},
+		})
+		pos = end
+	}
+	return records, nil
+}
+
+// 读取数据段id的提示文件，文件不存在或已损坏时返回错误。
+func (w *WriteSequence) readHints(id uint32) ([]hintRecord, error) {
+	data, err := os.ReadFile(filepath.Join(w.dataPath, hintName(id)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeHints(data)
+}
+
+// 为已封存的数据段id写入提示文件：先写入临时文件，按fsync策略落盘后再重命名，避免留下写了一半的提示文件。
+func (w *WriteSequence) writeHints(id uint32, records []hintRecord) error {
+	path := filepath.Join(w.dataPath, hintName(id))
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(encodeHints(records)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if w.fsync != FsyncNo {
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if w.fsync != FsyncNo {
+		return syncDir(w.dataPath)
+	}
+	return nil
+}
+
+/*
+扫描数据段id中的全部记录，返回对应的提示记录。active表示它是崩溃前的活跃段（编号最大的数据段）。
+崩溃时活跃段的最后几条记录可能没有写完，或者写入的内容已损坏：之后没有完好的记录时，从第一条损坏的记录处截断活跃段，
+丢弃的字节数和记录数累加到w.recovery。已封存的数据段不会再被写入，它末尾的损坏不可能来自未写完的记录，
+因此从不截断，与中间的损坏一样跳过。
+校验失败的记录之后还有完好的记录时（例如磁盘上的位翻转），说明损坏发生在数据段中间，截断会丢失之后的数据：
+按头部中的长度跳过该记录，继续扫描，跳过的字节数和记录数累加到w.recovery。
+*/
+func (w *WriteSequence) scanSegment(id uint32, f *DatabaseFile, active bool) ([]hintRecord, error) {
+	type corrupt struct {
+		offset, size int64
+	}
+	var records []hintRecord
+	var pending []corrupt // 最后一条完好的记录之后校验失败的记录
+	var cause error       // 第一条损坏的记录的错误
+	var offset int64 = FileHeaderSize
+	validEnd := offset // 最后一条完好的记录的结束位置
+	for offset < f.GetOffset() {
+		entry, err := f.Read(offset)
+		if errors.Is(err, ErrChecksum) {
+			size, sizeErr := f.sizeAt(offset)
+			if sizeErr != nil {
+				return nil, sizeErr
+			}
+			if len(pending) == 0 {
+				cause = err
+			}
+			pending = append(pending, corrupt{offset, size})
+			offset += size
+			continue
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			if len(pending) == 0 {
+				cause = err
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range pending {
+			w.recovery.SkippedBytes += c.size
+			w.recovery.SkippedEntries++
+			fmt.Println("skip corrupt entry in ", f.File.Name(), " at offset ", c.offset, ", size ", c.size)
+		}
+		pending = pending[:0]
+		records = append(records, hintRecord{
+			key:  string(entry.Key),
+			mark: entry.Mark,
+			loc:  Location{Segment: id, Offset: offset, Size: uint32(entry.Size()), Timestamp: entry.Timestamp},
+		})
+		offset += int64(entry.Size())
+		validEnd = offset
+	}
+	if validEnd < f.GetOffset() && active {
+		return records, w.truncate(f, validEnd, cause)
+	}
+	if validEnd < f.GetOffset() {
+		end := f.GetOffset()
+		entries := f.countEntries(validEnd)
+		w.recovery.SkippedBytes += end - validEnd
+		w.recovery.SkippedEntries += entries
+		fmt.Println("skip corrupt tail of sealed segment ", f.File.Name(), " at offset ", validEnd, ": ", cause,
+			", skipped bytes: ", end-validEnd, " entries: ", entries)
+	}
+	return records, nil
+}
+
+// 按记录头部中的长度估算从offset到数据段末尾的记录数。
+func (f *DatabaseFile) countEntries(offset int64) int64 {
+	var entries int64
+	for pos := offset; pos < f.GetOffset(); entries++ {
+		size, err := f.sizeAt(pos)
+		if err != nil {
+			return entries + 1
+		}
+		pos += size
+	}
+	return entries
+}
+
+// 按头部中的长度返回offset处的记录的大小，不校验CRC。
+func (f *DatabaseFile) sizeAt(offset int64) (int64, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := f.File.ReadAt(header, offset); err != nil {
+		return 0, err
+	}
+	entry, err := Decode(header)
+	if err != nil {
+		return 0, err
+	}
+	return int64(entry.Size()), nil
+}
+
+// 从offset处截断数据段f，丢弃的记录数按记录头部中的长度估算。
+func (w *WriteSequence) truncate(f *DatabaseFile, offset int64, cause error) error {
+	end := f.GetOffset()
+	entries := f.countEntries(offset)
+	if err := f.File.Truncate(offset); err != nil {
+		return err
+	}
+	f.UpdateOffset(offset)
+	w.recovery.TruncatedBytes += end - offset
+	w.recovery.DiscardedEntries += entries
+	fmt.Println("truncate corrupt tail of ", f.File.Name(), " at offset ", offset, ": ", cause,
+		", discarded bytes: ", end-offset, " entries: ", entries)
+	return nil
+}
+
+// 按提示记录更新索引。
+func (w *WriteSequence) apply(records []hintRecord) {
+	for _, r := range records {
+		if r.mark == DEL {
+			w.removeLocation(r.key)
+		} else {
+			w.setLocation(r.key, r.loc)
+		}
+	}
+}
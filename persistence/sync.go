@@ -0,0 +1,116 @@
+package persistence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+/*
+FsyncPolicy 决定写入的数据何时通过fsync落盘，与Redis AOF的appendfsync类似：
+FsyncAlways 在每次Put和Delete返回前fsync，操作系统崩溃也不会丢失已返回的写入，但每次写入都要等待磁盘；
+FsyncInterval 由后台goroutine每隔FsyncInterval对有新写入的文件fsync一次，最多丢失这段时间内的写入；
+FsyncNo 不主动fsync，由操作系统决定何时写回磁盘，进程崩溃不会丢失数据，操作系统崩溃时可能丢失较多写入。
+除FsyncNo外，Backup在返回前会fsync备份文件及其所在目录。压缩（包括Merge）删除旧数据段前总是fsync活跃段和数据目录，与fsync策略无关：
+否则操作系统崩溃时，旧数据段已被删除，而追加到活跃段的记录还没有写回磁盘，数据会永久丢失。
+*/
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"   // 每次写入后fsync
+	FsyncInterval FsyncPolicy = "interval" // 后台定期fsync
+	FsyncNo       FsyncPolicy = "no"       // 由操作系统决定，默认策略
+)
+
+const DefaultFsyncInterval = time.Second
+
+// Options 是WriteSequence的可选配置。
+type Options struct {
+	Fsync         FsyncPolicy   // fsync策略，为空时使用FsyncNo
+	FsyncInterval time.Duration // Fsync为FsyncInterval时的间隔，小于等于0时使用DefaultFsyncInterval
+	SegmentSize   int64         // 数据段的大小上限，超过后切换到新的数据段，小于等于0时使用DefaultSegmentSize
+
+	// 后台压缩的配置，见compact.go。为0时使用默认值，小于0时关闭对应的触发条件。
+	CompactRatio    float64       // 数据段中无效数据的比例达到该值时压缩该数据段，默认为DefaultCompactRatio
+	CompactBytes    int64         // 已封存数据段的无效数据合计达到该值时全部压缩，默认为DefaultCompactBytes
+	CompactInterval time.Duration // 检查是否需要压缩的间隔，默认为DefaultCompactInterval，小于0时不自动压缩
+}
+
+// 检查fsync策略是否有效，为空时使用FsyncNo。
+func (p FsyncPolicy) validate() (FsyncPolicy, error) {
+	switch p {
+	case "":
+		return FsyncNo, nil
+	case FsyncAlways, FsyncInterval, FsyncNo:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown fsync policy: %q", p)
+}
+
+// Sync 将文件已写入的内容fsync到磁盘。
+func (f *DatabaseFile) Sync() error {
+	return f.File.Sync()
+}
+
+// 写入一条记录后按fsync策略处理。调用者需持有w.mutex。
+func (w *WriteSequence) afterWrite() error {
+	switch w.fsync {
+	case FsyncAlways:
+		return w.databaseFile.Sync()
+	case FsyncInterval:
+		atomic.StoreInt32(&w.dirty, 1)
+	}
+	return nil
+}
+
+// 每隔interval对有新写入的数据文件fsync一次，直到Close。
+func (w *WriteSequence) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.syncIfDirty(); err != nil {
+				fmt.Println("syncLoop() error: ", err)
+			}
+		}
+	}
+}
+
+func (w *WriteSequence) syncIfDirty() error {
+	if !atomic.CompareAndSwapInt32(&w.dirty, 1, 0) {
+		return nil
+	}
+	w.mutex.RLock() // 切换数据段时会替换活跃段
+	defer w.mutex.RUnlock()
+	if err := w.databaseFile.Sync(); err != nil {
+		atomic.StoreInt32(&w.dirty, 1) // 下次重试
+		return err
+	}
+	return nil
+}
+
+// 按fsync策略将Backup写入的文件及其所在的目录落盘。备份文件可能不在数据目录中。
+func (w *WriteSequence) syncFile(file *os.File) error {
+	if w.fsync == FsyncNo {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(file.Name()))
+}
+
+// fsync目录，使目录中新建和重命名的文件落盘。
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,26 @@
+package policy
+
+import "time"
+
+/*
+Policy 是缓存淘汰策略的接口，cache 通过该接口使用具体的淘汰算法。
+实现该接口的结构体均为无锁的缓存器，并发控制由调用方（cache）负责。
+容量均以字节计算：maxBytes为0表示没有限制。
+目前的实现有：lru（最近最少使用）、lfu（最不经常使用）、arc（自适应替换缓存）、tinylfu（W-TinyLFU）。
+*/
+type Policy interface {
+	Get(key string) (Value, bool)                               // 查找key，命中时会更新访问信息；记录过期则视为未命中
+	Add(key string, val Value) bool                             // 插入或更新一条永不过期的记录，返回记录是否留在缓存中
+	AddWithExpire(key string, val Value, expire time.Time) bool // 插入或更新一条在expire时刻过期的记录，返回值同Add
+	Remove(key string)                                          // 移除key对应的记录
+	RemoveExpired() int                                         // 移除全部已过期的记录，返回移除的数量
+	Len() int                                                   // 记录数量
+	Keys() []string                                             // 全部未过期记录的key，不更新访问信息
+	GetCurrentUsedBytes() int64                                 // 当前已使用的内存
+	GetMaxUsedBytes() int64                                     // 允许使用的最大内存
+}
+
+// 可计算大小的值
+type Value interface {
+	Len() int64
+}
@@ -0,0 +1,227 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+/*
+RebalanceProgress 是本节点最近一次key迁移的进度。
+一致性哈希中的节点发生变化（Set、AddPeers、RemovePeers、健康检查或gossip）后，本节点检查自己保存的每个key：
+按新的一致性哈希，本节点不再是key的主节点或副本时，将key以原有版本发送给它现在的全部副本，全部成功后删除本地的数据。
+开启持久化时，已被移出内存的key也从持久化文件中读取并迁移。新节点上版本更新的值不会被覆盖。
+迁移进行中节点再次变化时，当前的迁移被取消，按最新的一致性哈希重新开始。
+*/
+type RebalanceProgress struct {
+	Running  bool      // 是否正在迁移
+	Total    int64     // 需要检查的key数
+	Scanned  int64     // 已检查的key数
+	Moved    int64     // 已迁移的key数
+	Failed   int64     // 迁移失败、仍保留在本节点的key数
+	Started  time.Time // 开始时间
+	Finished time.Time // 结束时间，迁移进行中为零值
+}
+
+// 在后台执行迁移，同一时刻最多只有一次迁移在进行。
+type rebalancer struct {
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{} // 当前的迁移结束时关闭
+	progress RebalanceProgress
+
+	moved  AtomicInt // 累计迁移的key数
+	failed AtomicInt // 累计迁移失败的key数
+}
+
+// 取消正在进行的迁移，在它结束后执行run。
+func (r *rebalancer) start(run func(ctx context.Context)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	prev, done := r.done, make(chan struct{})
+	r.cancel, r.done = cancel, done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		if ctx.Err() == nil {
+			run(ctx)
+		}
+	}()
+}
+
+func (r *rebalancer) begin(total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = RebalanceProgress{Running: true, Total: total, Started: time.Now()}
+}
+
+// 记录一个key的检查结果，err不为nil表示迁移失败。
+func (r *rebalancer) record(moved bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.Scanned++
+	if err != nil {
+		r.progress.Failed++
+		r.failed.Add(1)
+	} else if moved {
+		r.progress.Moved++
+		r.moved.Add(1)
+	}
+}
+
+func (r *rebalancer) finish() RebalanceProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.Running = false
+	r.progress.Finished = time.Now()
+	return r.progress
+}
+
+func (r *rebalancer) snapshot() RebalanceProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// RebalanceProgress 返回本节点最近一次key迁移的进度。
+func (p *HTTPPool) RebalanceProgress() RebalanceProgress {
+	return p.rebalance.snapshot()
+}
+
+func (p *HTTPPool) rebalanceState() *pb.RebalanceState {
+	progress := p.RebalanceProgress()
+	return &pb.RebalanceState{
+		Running: progress.Running,
+		Total:   progress.Total,
+		Scanned: progress.Scanned,
+		Moved:   progress.Moved,
+		Failed:  progress.Failed,
+	}
+}
+
+// 一致性哈希发生变化后调用，在后台开始迁移。调用者需持有p.mu。
+func (p *HTTPPool) ringChanged() {
+	if p.opts.RebalanceRate < 0 {
+		return
+	}
+	p.rebalance.start(p.runRebalance)
+}
+
+/*
+检查使用本HTTPPool的全部Group中的key，迁移本节点不再负责的key。
+每秒最多迁移RebalanceRate个key，避免迁移占满网络和新节点的写入。
+*/
+func (p *HTTPPool) runRebalance(ctx context.Context) {
+	type groupKeys struct {
+		group *Group
+		keys  []string
+	}
+	var todo []groupKeys
+	var total int64
+	for _, g := range groupsUsing(p) {
+		keys := g.mainCache.keys()
+		todo = append(todo, groupKeys{g, keys})
+		total += int64(len(keys))
+	}
+	p.rebalance.begin(total)
+	interval := time.Second / time.Duration(p.opts.RebalanceRate)
+	if interval <= 0 { // RebalanceRate超过每秒10^9时，间隔不足1纳秒
+		interval = time.Nanosecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for _, t := range todo {
+		for _, key := range t.keys {
+			if ctx.Err() != nil {
+				p.rebalance.finish()
+				return
+			}
+			owners, self, ok := p.owners(key)
+			if !ok || self {
+				p.rebalance.record(false, nil)
+				continue
+			}
+			value, found := t.group.mainCache.getVersioned(key)
+			if !found {
+				p.rebalance.record(false, nil)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				p.rebalance.finish()
+				return
+			case <-ticker.C:
+			}
+			err := t.group.handOff(ctx, key, value, owners)
+			if err != nil {
+				log.Println("[myCache] Failed to move key to its new owners", err)
+			}
+			p.rebalance.record(true, err)
+		}
+	}
+	progress := p.rebalance.finish()
+	if progress.Moved > 0 || progress.Failed > 0 {
+		p.Log("rebalance finished in %v: scanned %d, moved %d, failed %d",
+			progress.Finished.Sub(progress.Started), progress.Scanned, progress.Moved, progress.Failed)
+	}
+}
+
+// 按名称排序返回使用peers的全部Group。
+func groupsUsing(peers PeerPicker) []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	var ans []*Group
+	for _, g := range groups {
+		if g.peers == peers {
+			ans = append(ans, g)
+		}
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i].name < ans[j].name })
+	return ans
+}
+
+// 返回key现在的副本中的远程节点，以及本节点是否也是副本，与节点的负载无关（见ringNodes）。一致性哈希为空时ok为false。
+func (p *HTTPPool) owners(key string) (peers []PeerGetter, self bool, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false, false
+	}
+	nodes := p.ringNodes(key, p.opts.ReplicaCount)
+	for _, node := range nodes {
+		if node == p.self {
+			self = true
+		} else {
+			peers = append(peers, p.httpGetters[node])
+		}
+	}
+	return peers, self, len(nodes) > 0
+}
+
+// 将本地保存的key以原有版本写入peers，全部成功后删除本地的数据（迁移期间本地写入了更新的值时保留）。
+func (g *Group) handOff(ctx context.Context, key string, value ByteView, peers []PeerGetter) error {
+	req := g.setRequest(key, value)
+	var errs []error
+	for _, peer := range peers {
+		if err := setReplica(ctx, peer, req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("move %q: %d of %d peers failed: %w", key, len(errs), len(peers), errors.Join(errs...))
+	}
+	return g.mainCache.deleteIfNotNewer(key, value.version)
+}
@@ -0,0 +1,98 @@
+package mycache
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mycache/consistenthash"
+)
+
+func TestHTTPRebalance(t *testing.T) {
+	remote := newTestGroup(t, Conf{Name: "rebalance-remote"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("moved keys should not be loaded: " + key)
+	}))
+	_, server := newRemoteNode(t, "http://remote", "rebalance-local", remote)
+
+	g := newTestGroup(t, Conf{Name: "rebalance-local"}, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}))
+	const rate = 100
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{RebalanceRate: rate})
+	g.RegisterPeers(pool)
+	pool.Set("http://self")
+
+	const n = 20
+	versions := make(map[string]uint64)
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := g.Set(key, []byte("v-"+key)); err != nil {
+			t.Fatal(err)
+		}
+		v, _ := g.mainCache.getVersioned(key)
+		versions[key] = v.version
+	}
+	waitFor(t, "the initial rebalance", func() bool { return pool.RebalanceProgress().Finished.After(time.Time{}) })
+
+	start := time.Now()
+	pool.AddPeers(server.URL)
+	waitFor(t, "keys to move", func() bool {
+		progress := pool.RebalanceProgress()
+		return !progress.Running && progress.Started.After(start) && progress.Scanned == n
+	})
+	elapsed := time.Since(start)
+
+	moved := 0
+	for key, version := range versions {
+		_, self, _ := pool.owners(key)
+		_, local := g.mainCache.getVersioned(key)
+		v, ok := remote.mainCache.getVersioned(key)
+		if self {
+			if !local || ok {
+				t.Errorf("%s is still owned by this node, local copy %v, remote copy %v", key, local, ok)
+			}
+			continue
+		}
+		moved++
+		if local || !ok || v.String() != "v-"+key || v.version != version {
+			t.Errorf("%s: local copy %v, remote copy %q at %d, %v; want it moved with version %d", key, local, v.String(), v.version, ok, version)
+		}
+	}
+	progress := pool.RebalanceProgress()
+	if moved == 0 || progress.Moved != int64(moved) || progress.Failed != 0 {
+		t.Fatalf("progress = %+v, want %d keys moved", progress, moved)
+	}
+	if min := time.Duration(moved-1) * time.Second / rate; elapsed < min {
+		t.Fatalf("moved %d keys in %v, want at least %v at %d keys per second", moved, elapsed, min, rate)
+	}
+}
+
+// 使用有界负载时，key的归属由哈希环决定，节点繁忙时不会触发迁移。
+func TestOwnersIgnoreLoad(t *testing.T) {
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{Placement: consistenthash.BoundedLoads, RebalanceRate: -1})
+	pool.Set("http://self", "http://a", "http://b")
+	before := make(map[string][]PeerGetter)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		before[key], _, _ = pool.owners(key)
+	}
+	pool.inflight.Add(100)
+	defer pool.inflight.Add(-100)
+	pool.PickPeer("0") // 向哈希环报告新的负载
+	for key, want := range before {
+		if got, _, _ := pool.owners(key); !reflect.DeepEqual(got, want) {
+			t.Fatalf("owners of %s changed with load: %v, want %v", key, got, want)
+		}
+	}
+}
+
+// RebalanceRate很大时迁移间隔不足1纳秒，不能因此panic。
+func TestRebalanceHighRate(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "rebalance-high-rate"}, 2<<10, originGetter())
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{RebalanceRate: 2e9})
+	g.RegisterPeers(pool)
+	pool.Set("http://self")
+	waitFor(t, "the rebalance", func() bool { return pool.RebalanceProgress().Finished.After(time.Time{}) })
+}
@@ -0,0 +1,301 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+/*
+Consistency 是副本读写的一致性级别，通过Conf.Consistency为每个Group单独设置。
+key的副本数n由PeerPicker决定（例如HTTPPoolOptions.ReplicaCount），写入在足够多的副本确认后才返回成功：
+ONE为1个，QUORUM为n/2+1个，ALL为全部n个。
+读取时，QUORUM和ALL向同样数量的副本读取，取版本（写入时的毫秒时间戳）最新的值，并把它写回版本较旧的副本（read repair）；
+写入和读取的副本数之和大于n时，读取一定能看到最近一次成功的写入。ONE只读取第一个可用的副本。
+*/
+type Consistency string
+
+const (
+	ConsistencyOne    Consistency = "one"    // 一个副本确认即可，默认级别
+	ConsistencyQuorum Consistency = "quorum" // 多数副本确认
+	ConsistencyAll    Consistency = "all"    // 全部副本确认
+)
+
+// 检查一致性级别是否有效，为空时使用ConsistencyOne。
+func (c Consistency) validate() (Consistency, error) {
+	switch c {
+	case "":
+		return ConsistencyOne, nil
+	case ConsistencyOne, ConsistencyQuorum, ConsistencyAll:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown consistency level: %q", c)
+}
+
+// 共有n个副本时，需要确认的副本数。
+func (c Consistency) required(n int) int {
+	switch c {
+	case ConsistencyQuorum:
+		return n/2 + 1
+	case ConsistencyAll:
+		return n
+	}
+	return 1
+}
+
+/*
+返回key的副本中的远程节点，以及本节点是否也是副本。
+ok为false表示没有启用副本：g.peers未实现ReplicaPicker，或者副本数为1，此时按原来的方式只由一个节点负责。
+*/
+func (g *Group) replicas(key string) (peers []PeerGetter, self bool, ok bool) {
+	rp, isReplica := g.peers.(ReplicaPicker)
+	if !isReplica {
+		return nil, false, false
+	}
+	peers, self = rp.PickReplicas(key)
+	n := len(peers)
+	if self {
+		n++
+	}
+	return peers, self, n > 1
+}
+
+/*
+将value（需带有版本）写入key的全部副本，在足够多的副本确认后返回nil。
+其余副本的写入在后台继续完成。确认的副本数不足时返回错误，其中列出失败的节点。
+*/
+func (g *Group) writeReplicas(key string, value ByteView, peers []PeerGetter, self bool) error {
+	n := len(peers)
+	if self {
+		n++
+	}
+	need := g.consistency.required(n)
+	results := make(chan error, n)
+	if self {
+		_, err := g.mainCache.addIfNewer(key, value, g.defaultTTL)
+		results <- err
+	}
+	req := g.setRequest(key, value)
+	for _, peer := range peers {
+		go func(peer PeerGetter) {
+			results <- setReplica(context.Background(), peer, req)
+		}(peer)
+	}
+	return waitReplicas(fmt.Sprintf("set %q", key), results, n, need)
+}
+
+/*
+在key的全部副本上删除key，并广播给其他节点删除它们可能保存的数据。
+只有副本的删除结果计入一致性级别，其他节点删除失败时只记录日志。
+删除不保留墓碑：错过删除的副本在之后的读取中可能把旧值写回其他副本。
+*/
+func (g *Group) deleteReplicas(key string, peers []PeerGetter, self bool) error {
+	n := len(peers)
+	if self {
+		n++
+	}
+	need := g.consistency.required(n)
+	results := make(chan error, n)
+	err := g.deleteLocally(key)
+	if self {
+		results <- err
+	} else if err != nil {
+		return err
+	}
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	isReplica := make(map[PeerGetter]bool, len(peers))
+	for _, peer := range peers {
+		isReplica[peer] = true
+		go func(peer PeerGetter) {
+			results <- peer.Delete(req)
+		}(peer)
+	}
+	for _, peer := range g.peers.GetAll() {
+		if isReplica[peer] {
+			continue
+		}
+		go func(peer PeerGetter) {
+			if err := peer.Delete(req); err != nil {
+				log.Println("[myCache] Failed to delete from peer", err)
+			}
+		}(peer)
+	}
+	return waitReplicas(fmt.Sprintf("delete %q", key), results, n, need)
+}
+
+// 从results中等待n个副本的结果，need个成功时返回nil，失败的副本多到无法满足need时返回错误。
+func waitReplicas(op string, results <-chan error, n, need int) error {
+	acks := 0
+	var errs []error
+	for i := 0; i < n; i++ {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		} else {
+			acks++
+		}
+		if acks >= need {
+			return nil
+		}
+		if len(errs) > n-need {
+			break
+		}
+	}
+	return fmt.Errorf("%s: %d of %d replicas acknowledged, %d required: %w", op, acks, n, need, errors.Join(errs...))
+}
+
+/*
+按一致性级别向key的副本读取，返回版本最新的值，并把它写回版本较旧或没有该key的副本。
+ok为false表示应按原来的方式获取：一致性级别为ONE、没有启用副本、没有副本保存着key，或者响应的副本数不足。
+*/
+func (g *Group) readReplicas(ctx context.Context, key string) (value ByteView, ok bool) {
+	if g.consistency == ConsistencyOne {
+		return ByteView{}, false
+	}
+	peers, self, enabled := g.replicas(key)
+	if !enabled {
+		return ByteView{}, false
+	}
+	n := len(peers)
+	if self {
+		n++
+	}
+	need := g.consistency.required(n)
+
+	type reply struct {
+		peer  PeerGetter
+		value ByteView
+		found bool
+	}
+	replies := make(chan reply, len(peers))
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	for _, peer := range peers {
+		go func(peer PeerGetter) {
+			res := &pb.KVResponse{}
+			if err := getReplica(ctx, peer, req, res); err != nil {
+				g.stats.PeerErrors.Add(1)
+				log.Println("[myCache] Failed to read replica from peer", err)
+				replies <- reply{}
+				return
+			}
+			replies <- reply{peer, ByteView{data: res.Value, version: res.Timestamp, expire: expireFromMillis(res.Expire)}, res.Timestamp > 0}
+		}(peer)
+	}
+
+	var latest ByteView
+	found, responded := false, 0
+	var versions []reply // 已响应的远程副本及其版本
+	if self {
+		latest, found = g.mainCache.getVersioned(key)
+		responded++
+	}
+	fromPeer := false
+	for i := 0; i < len(peers) && responded < need; i++ {
+		r := <-replies
+		if r.peer == nil {
+			continue
+		}
+		responded++
+		versions = append(versions, r)
+		if r.found && (!found || r.value.version > latest.version) {
+			latest, found, fromPeer = r.value, true, true
+		}
+	}
+	if responded < need || !found {
+		return ByteView{}, false
+	}
+	if fromPeer {
+		g.stats.PeerLoads.Add(1)
+	}
+
+	// read repair：在后台把最新的值写回较旧的副本。
+	var stale []PeerGetter
+	for _, r := range versions {
+		if !r.found || r.value.version < latest.version {
+			stale = append(stale, r.peer)
+		}
+	}
+	if self && fromPeer {
+		if _, err := g.mainCache.addIfNewer(key, latest, g.defaultTTL); err != nil {
+			log.Println("[myCache] Failed to repair local replica", err)
+		}
+	}
+	if len(stale) > 0 {
+		go g.repairReplicas(key, latest, stale)
+	}
+	return latest, true
+}
+
+// 将value写回版本较旧的副本，失败时只记录日志。
+func (g *Group) repairReplicas(key string, value ByteView, peers []PeerGetter) {
+	req := g.setRequest(key, value)
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := setReplica(context.Background(), peer, req); err != nil {
+				log.Println("[myCache] Failed to repair replica on peer", err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// 本节点从数据源导入key后，若本节点是key的副本之一，在后台将值复制到其他副本。
+func (g *Group) replicatePopulate(key string, value ByteView) {
+	peers, self, ok := g.replicas(key)
+	if !ok || !self || len(peers) == 0 {
+		return
+	}
+	go g.repairReplicas(key, value, peers)
+}
+
+/*
+由本节点保存从其他节点复制来的副本，本地版本更新时忽略。
+expire为零值（发送方不知道过期时间，或旧版本的节点）时以Conf.DefaultTTL为有效期。
+*/
+func (g *Group) setReplicaLocally(key string, value []byte, version uint64, expire time.Time) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	_, err := g.mainCache.addIfNewer(key, ByteView{data: cloneBytes(value), version: version, expire: expire}, g.defaultTTL)
+	return err
+}
+
+// 复制value的请求，带有value的版本和过期时间。
+func (g *Group) setRequest(key string, value ByteView) *pb.SetRequest {
+	return &pb.SetRequest{
+		Group:     g.name,
+		Key:       key,
+		Value:     value.data,
+		Timestamp: value.version,
+		Expire:    value.expireMillis(),
+	}
+}
+
+func getReplica(ctx context.Context, peer PeerGetter, in *pb.Request, out *pb.KVResponse) error {
+	rg, ok := peer.(ReplicaGetter)
+	if !ok {
+		return fmt.Errorf("peer %T does not support replicas", peer)
+	}
+	return rg.GetReplica(ctx, in, out)
+}
+
+func setReplica(ctx context.Context, peer PeerGetter, in *pb.SetRequest) error {
+	rg, ok := peer.(ReplicaGetter)
+	if !ok {
+		return fmt.Errorf("peer %T does not support replicas", peer)
+	}
+	return rg.SetReplica(ctx, in)
+}
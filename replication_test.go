@@ -0,0 +1,259 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "mycache/mycachepb"
+)
+
+// 在内存中保存副本的远程节点，down为true时全部请求失败。
+type fakeReplica struct {
+	mu   sync.Mutex
+	data map[string]ByteView
+	down bool
+}
+
+func newFakeReplica() *fakeReplica {
+	return &fakeReplica{data: make(map[string]ByteView)}
+}
+
+func (f *fakeReplica) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeReplica) get(key string) (ByteView, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.data[key]
+	return v, ok
+}
+
+func (f *fakeReplica) Get(in *pb.Request, out *pb.KVResponse) error {
+	if err := f.GetReplica(context.Background(), in, out); err != nil {
+		return err
+	}
+	if out.Timestamp == 0 {
+		return errors.New(in.Key + " not exist")
+	}
+	return nil
+}
+
+func (f *fakeReplica) Set(in *pb.SetRequest) error {
+	return errors.New("replicas are written with SetReplica")
+}
+
+func (f *fakeReplica) Delete(in *pb.Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("replica is down")
+	}
+	delete(f.data, in.Key)
+	return nil
+}
+
+func (f *fakeReplica) GetReplica(ctx context.Context, in *pb.Request, out *pb.KVResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("replica is down")
+	}
+	v := f.data[in.Key]
+	out.Value, out.Timestamp, out.Expire = v.data, v.version, v.expireMillis()
+	return nil
+}
+
+func (f *fakeReplica) SetReplica(ctx context.Context, in *pb.SetRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("replica is down")
+	}
+	if f.data[in.Key].version <= in.Timestamp {
+		f.data[in.Key] = ByteView{data: in.Value, version: in.Timestamp, expire: expireFromMillis(in.Expire)}
+	}
+	return nil
+}
+
+// 每个key都由同一组副本负责的ReplicaPicker，self表示本节点是最后一个副本。
+type fixedReplicas struct {
+	peers []PeerGetter
+	self  bool
+}
+
+func (p *fixedReplicas) PickPeer(key string) (PeerGetter, bool) { return p.peers[0], true }
+func (p *fixedReplicas) GetAll() []PeerGetter                   { return p.peers }
+func (p *fixedReplicas) PickPeers(key string) []PeerGetter      { return p.peers }
+func (p *fixedReplicas) PickReplicas(key string) ([]PeerGetter, bool) {
+	return p.peers, p.self
+}
+
+func originGetter() Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin-" + key), nil
+	})
+}
+
+func TestReplicatedWrites(t *testing.T) {
+	a, b := newFakeReplica(), newFakeReplica()
+	g := newTestGroup(t, Conf{Name: "replica-quorum", Consistency: ConsistencyQuorum}, 2<<10, originGetter())
+	g.RegisterPeers(&fixedReplicas{peers: []PeerGetter{a, b}, self: true})
+
+	// 3个副本中的2个确认即可。
+	b.setDown(true)
+	if err := g.Set("k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := a.get("k"); !ok || v.String() != "v1" || v.version == 0 {
+		t.Fatalf("replica a has %q (version %d), want v1", v.String(), v.version)
+	}
+	if v, ok := g.mainCache.get("k"); !ok || v.String() != "v1" {
+		t.Fatalf("local replica has %q, want v1", v.String())
+	}
+	a.setDown(true)
+	err := g.Set("k", []byte("v2"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 replicas acknowledged, 2 required") {
+		t.Fatalf("set with 2 replicas down returned %v", err)
+	}
+	if err := g.Delete("k"); err == nil {
+		t.Fatal("delete with 2 replicas down should fail")
+	}
+	a.setDown(false)
+	if err := g.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.get("k"); ok {
+		t.Fatal("k should be deleted on replica a")
+	}
+
+	all := newTestGroup(t, Conf{Name: "replica-all", Consistency: ConsistencyAll}, 2<<10, originGetter())
+	all.RegisterPeers(&fixedReplicas{peers: []PeerGetter{a, b}, self: true})
+	if err := all.Set("k", []byte("v")); err == nil {
+		t.Fatal("set with consistency all should fail when a replica is down")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unknown consistency level should panic")
+		}
+	}()
+	NewGroup(Conf{Name: "replica-unknown", Consistency: "two"}, 2<<10, originGetter())
+}
+
+// 写入和导入的值带着过期时间复制到其他副本。
+func TestReplicatedTTL(t *testing.T) {
+	a := newFakeReplica()
+	g := newTestGroup(t, Conf{Name: "replica-ttl", DefaultTTL: time.Minute}, 2<<10, ttlGetter{ttl: time.Hour})
+	g.RegisterPeers(&fixedReplicas{peers: []PeerGetter{a}, self: true})
+
+	if err := g.Set("set", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the written value to be replicated", func() bool {
+		_, ok := a.get("set")
+		return ok
+	})
+	if v, _ := a.get("set"); time.Until(v.expire) < 50*time.Second || time.Until(v.expire) > time.Minute {
+		t.Fatalf("replica of set expires in %v, want the DefaultTTL", time.Until(v.expire))
+	}
+	if _, err := g.Get("loaded"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loaded value to be replicated", func() bool {
+		_, ok := a.get("loaded")
+		return ok
+	})
+	if v, _ := a.get("loaded"); time.Until(v.expire) < 59*time.Minute {
+		t.Fatalf("replica of loaded expires in %v, want the getter's TTL", time.Until(v.expire))
+	}
+}
+
+// 对每个key返回固定ttl的TTLGetter。
+type ttlGetter struct {
+	ttl time.Duration
+}
+
+func (g ttlGetter) Get(key string) ([]byte, error) {
+	return []byte("v-" + key), nil
+}
+
+func (g ttlGetter) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return []byte("v-" + key), g.ttl, nil
+}
+
+func TestReplicatedReadRepair(t *testing.T) {
+	a, b := newFakeReplica(), newFakeReplica()
+	a.data["k"] = ByteView{data: []byte("old"), version: 100}
+	b.data["k"] = ByteView{data: []byte("new"), version: 200}
+	g := newTestGroup(t, Conf{Name: "replica-repair", Consistency: ConsistencyQuorum}, 2<<10, originGetter())
+	g.RegisterPeers(&fixedReplicas{peers: []PeerGetter{a, b}})
+
+	if v, err := g.Get("k"); err != nil || v.String() != "new" {
+		t.Fatalf("get k = %q, %v; want the newest copy", v.String(), err)
+	}
+	waitFor(t, "replica a to be repaired", func() bool {
+		v, _ := a.get("k")
+		return v.version == 200 && v.String() == "new"
+	})
+
+	// 没有副本保存着key时，按原来的方式从主节点获取，主节点失败后回源。
+	if v, err := g.Get("missing"); err != nil || v.String() != "origin-missing" {
+		t.Fatalf("get missing = %q, %v; want the origin value", v.String(), err)
+	}
+}
+
+// 支持批量获取的fakeReplica，批量请求只返回它自己保存的值。
+type batchReplica struct {
+	*fakeReplica
+}
+
+func (b batchReplica) GetMulti(ctx context.Context, in *pb.MultiRequest, out *pb.MultiResponse) error {
+	for _, key := range in.Keys {
+		v, _ := b.get(key)
+		out.Results = append(out.Results, &pb.KVResult{Key: key, Value: v.data})
+	}
+	return nil
+}
+
+// 一致性级别不是ONE时，GetMulti与Get相同，返回各副本中版本最新的值，而不是主节点上的值。
+func TestReplicatedGetMulti(t *testing.T) {
+	a, b := newFakeReplica(), newFakeReplica()
+	a.data["k"] = ByteView{data: []byte("old"), version: 100}
+	b.data["k"] = ByteView{data: []byte("new"), version: 200}
+	g := newTestGroup(t, Conf{Name: "replica-multi", Consistency: ConsistencyAll}, 2<<10, originGetter())
+	g.RegisterPeers(&fixedReplicas{peers: []PeerGetter{batchReplica{a}, b}})
+
+	values, errs := g.GetMulti([]string{"k", "missing"})
+	if errs[0] != nil || values[0].String() != "new" {
+		t.Fatalf("get multi k = %q, %v; want the newest copy", values[0].String(), errs[0])
+	}
+	if errs[1] != nil || values[1].String() != "origin-missing" {
+		t.Fatalf("get multi missing = %q, %v; want the origin value", values[1].String(), errs[1])
+	}
+}
+
+// 只保存在持久化文件中的副本同样会过期，过期后不再作为本地副本返回。
+func TestReplicaExpiresOnDisk(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "replica-disk-ttl", EnablePersistence: true, PersistencePath: t.TempDir(), DefaultTTL: 30 * time.Millisecond},
+		2<<10, originGetter())
+	if err := g.Set("k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.mainCache.getVersioned("k"); !ok || v.String() != "v1" || v.expire.IsZero() {
+		t.Fatalf("get versioned k = %q, %v, expire %v; want v1 with an expiry", v.String(), ok, v.expire)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatal("expired k was served from memory")
+	}
+	if v, ok := g.mainCache.getVersioned("k"); ok {
+		t.Fatalf("expired k was served from disk: %q, expire %v", v.String(), v.expire)
+	}
+}
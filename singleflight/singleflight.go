@@ -12,21 +12,20 @@ package singleflight // single flight 单程飞行。提供了一种抑制重复
 */
 
 import (
+	"context"
 	"sync"
 )
 
 /*
-call 代表正在进行中，或已经结束的请求。使用 sync.WaitGroup 锁避免重入。
-如果wg的计数器大于等于1，说明正在向后方请求结果。如果计数器等于0，说明已经得到结果。
-新建sync.WaitGroup（等待组）时设置一个计数器，初始化为0。
-wg.Add(n) 计数器加n。
-wg.Done() 计数器减1。
-wg.Wait() 当计数器不等于 0 时阻塞，直到变 0。
+call 代表正在进行中，或已经结束的请求。
+done未关闭，说明正在向后方请求结果；done被关闭，说明已经得到结果，val和err不再改变。
+等待结果的请求同时监听done和自己的ctx，ctx被取消或超时时不必继续等待。
 */
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done     chan struct{} // 得到结果后关闭
+	val      interface{}
+	err      error
+	canceled bool // fn失败时，执行fn的请求的ctx已被取消或超时，等待的请求应自行重试
 }
 
 // Call的Group。
@@ -45,28 +44,109 @@ fn是一个能返回key对应值的函数。fn函数的具体内容：
 若是本机节点或远程获取失败，则回退到 getLocally()。
 */
 func (g *GroupCall) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoContext(context.Background(), key, fn)
+}
+
+/*
+DoContext 与 Do 相同，但等待其他请求的结果时，ctx 被取消或超时会立即返回 ctx.Err()。
+fn 由最早到来的请求执行，fn 需要自行监听该请求的 ctx。
+每个请求只因自己的 ctx 失败：如果执行fn的请求因为它的 ctx 被取消或超时而失败，
+等待的请求不会得到这个错误，而是重新调用DoContext，由其中最早的一个重新执行fn。
+*/
+func (g *GroupCall) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		c, leader := g.join(key)
+		if leader {
+			return g.run(ctx, key, c, fn)
+		}
+		select {
+		case <-c.done:
+			if c.canceled && ctx.Err() == nil {
+				continue
+			}
+			return c.val, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 查找key对应的请求。不存在则新建并登记，leader为true表示由调用者执行fn。
+func (g *GroupCall) join(key string) (c *call, leader bool) {
 	g.mu.Lock()
+	defer g.mu.Unlock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		return c, false
 	}
-	c := new(call)
-	c.wg.Add(1)
+	c = &call{done: make(chan struct{})}
 	g.m[key] = c
-	g.mu.Unlock()
+	return c, true
+}
 
-	c.val, c.err = fn()
-	c.wg.Done()
+func (g *GroupCall) run(ctx context.Context, key string, c *call, fn func() (interface{}, error)) (interface{}, error) {
+	val, err := fn()
+	g.finish(ctx, key, c, val, err)
+	return c.val, c.err
+}
+
+// 保存请求的结果，唤醒等待的请求，并将请求从m中删除。
+func (g *GroupCall) finish(ctx context.Context, key string, c *call, val interface{}, err error) {
+	c.val, c.err = val, err
+	c.canceled = err != nil && ctx.Err() != nil
+	close(c.done)
 
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
+}
 
-	return c.val, c.err
+/*
+DoMultiContext 是批量版本的DoContext，keys中不能有重复的key。
+没有正在进行中的请求的key由本次调用负责：通过一次fn调用获得它们的结果，fn返回的vals和errs必须与传入的keys一一对应；
+其他key等待进行中的请求的结果，与DoContext中的等待相同，对应的shared为true。
+*/
+func (g *GroupCall) DoMultiContext(ctx context.Context, keys []string, fn func(keys []string) ([]interface{}, []error)) (vals []interface{}, errs []error, shared []bool) {
+	vals, errs, shared = make([]interface{}, len(keys)), make([]error, len(keys)), make([]bool, len(keys))
+	calls := make([]*call, len(keys))
+	var own []string
+	var ownIdx []int
+	for i, key := range keys {
+		c, leader := g.join(key)
+		calls[i], shared[i] = c, !leader
+		if leader {
+			own, ownIdx = append(own, key), append(ownIdx, i)
+		}
+	}
+	if len(own) > 0 {
+		ownVals, ownErrs := fn(own)
+		for j, i := range ownIdx {
+			g.finish(ctx, keys[i], calls[i], ownVals[j], ownErrs[j])
+			vals[i], errs[i] = ownVals[j], ownErrs[j]
+		}
+	}
+	for i, key := range keys {
+		if !shared[i] {
+			continue
+		}
+		c := calls[i]
+		select {
+		case <-c.done:
+			if c.canceled && ctx.Err() == nil { // 执行请求的ctx被取消，由本次调用重试这个key
+				vals[i], errs[i] = g.DoContext(ctx, key, func() (interface{}, error) {
+					v, e := fn([]string{key})
+					return v[0], e[0]
+				})
+			} else {
+				vals[i], errs[i] = c.val, c.err
+			}
+		case <-ctx.Done():
+			vals[i], errs[i] = nil, ctx.Err()
+		}
+	}
+	return vals, errs, shared
 }
 
 /*
@@ -74,13 +154,13 @@ func (g *GroupCall) Do(key string, fn func() (interface{}, error)) (interface{},
 	第0秒协程A到来，第10秒协程B到来，第20秒协程C到来，这三个协程都是请求键值"张三"对应的值。
 	第0秒，协程A到来，为了防止自己读取（及修改）GroupCall时GroupCall被别人修改，所以使用mu锁定。
 	A查看GroupCall.m，发现并没有自己想要的值（的请求）。
-	新建一个请求call，设置计数器=1（表示正在请求资源），登记到GroupCall.m（由于之后不再修改GroupCall，所以解锁mu）。
+	新建一个请求call（done未关闭，表示正在请求资源），登记到GroupCall.m（由于之后不再修改GroupCall，所以解锁mu）。
 	调用fn()，向后方请求结果。（这里假定响应时间需要一分钟）
 	第10秒，协程B到来，查看GroupCall.m，发现已经存在自己想要的值的请求c（call）。
-	B调用c.wg.Wait()，发现计数器=1，也就是说：已经向后方进行了请求但还没有结果。于是B阻塞了。
-	第20秒，协程C到来。与B相似的，C发现自己需要的值已经有人在请求了（计数器=1），但还没有结果。于是C阻塞了。
-	第60秒，A得到了想要的结果（结果保存在call中），调用Done()使计数器变为0。
-	计数器=0后，B和C相继被唤醒。
+	B等待c.done，发现它还没有关闭，也就是说：已经向后方进行了请求但还没有结果。于是B阻塞了。
+	第20秒，协程C到来。与B相似的，C发现自己需要的值已经有人在请求了（c.done未关闭），但还没有结果。于是C阻塞了。
+	第60秒，A得到了想要的结果（结果保存在call中），关闭c.done。
+	c.done关闭后，B和C相继被唤醒。
 	B返回自己请求值对应call所保存的结果，结束任务。
 	A删除了了m中key对应的call（只是删除了GroupCall与这个call的联系，实际上ABC共享这个的call依然存在），返回结果。
 	C也返回自己请求值对应call所保存的结果，结束任务。
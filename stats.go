@@ -0,0 +1,61 @@
+package mycache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 是可以并发读写的int64计数器。
+type AtomicInt int64
+
+// Add 原子地将n加到i上。
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取i的值。
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+/*
+Stats 是一个Group的统计信息，全部字段都是原子计数器，可以并发读取。
+GetMulti中的每个key与一次Get同样计数。
+*/
+type Stats struct {
+	Gets            AtomicInt // 全部Get请求
+	Hits            AtomicInt // mainCache命中
+	Misses          AtomicInt // mainCache未命中
+	PeerLoads       AtomicInt // 从远程节点获取成功
+	PeerErrors      AtomicInt // 从远程节点获取失败
+	LocalLoads      AtomicInt // 通过getter从本地导入成功
+	LocalLoadErrors AtomicInt // 通过getter从本地导入失败
+	DedupedLoads    AtomicInt // 被single flight合并、直接使用其他请求结果的导入
+	Evictions       AtomicInt // 因容量不足或过期被移出mainCache的记录，不包括主动删除
+	DiskHits        AtomicInt // mainCache的内存中未命中、从磁盘层读取并放回内存的记录，同时计入Hits
+}
+
+// 原子地逐个读取计数器，返回一份快照。
+func (s *Stats) snapshot() Stats {
+	var ans Stats
+	ans.Gets.Add(s.Gets.Get())
+	ans.Hits.Add(s.Hits.Get())
+	ans.Misses.Add(s.Misses.Get())
+	ans.PeerLoads.Add(s.PeerLoads.Get())
+	ans.PeerErrors.Add(s.PeerErrors.Get())
+	ans.LocalLoads.Add(s.LocalLoads.Get())
+	ans.LocalLoadErrors.Add(s.LocalLoadErrors.Get())
+	ans.DedupedLoads.Add(s.DedupedLoads.Get())
+	ans.Evictions.Add(s.Evictions.Get())
+	ans.DiskHits.Add(s.DiskHits.Get())
+	return ans
+}
+
+// GetStats 返回Group统计信息的快照。
+func (g *Group) GetStats() Stats {
+	return g.stats.snapshot()
+}
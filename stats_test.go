@@ -0,0 +1,37 @@
+package mycache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	g := newTestGroup(t, Conf{Name: "stats", CacheShards: 1}, 50, GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "bad") {
+			return nil, errors.New("not found")
+		}
+		return []byte(strings.Repeat("v", 20)), nil
+	}))
+	g.Get("a")   // 未命中，导入成功
+	g.Get("a")   // 命中
+	g.Get("bad") // 未命中，导入失败
+	g.Get("b")
+	g.Get("c") // 容量只有50字节，放不下3条记录，淘汰a
+	g.Delete("b")
+
+	s := g.GetStats()
+	got := map[string]int64{
+		"Gets":            s.Gets.Get(),
+		"Hits":            s.Hits.Get(),
+		"Misses":          s.Misses.Get(),
+		"LocalLoads":      s.LocalLoads.Get(),
+		"LocalLoadErrors": s.LocalLoadErrors.Get(),
+		"Evictions":       s.Evictions.Get(),
+	}
+	for name, want := range map[string]int64{"Gets": 5, "Hits": 1, "Misses": 4, "LocalLoads": 3, "LocalLoadErrors": 1, "Evictions": 1} {
+		if got[name] != want {
+			t.Errorf("%s = %d, want %d", name, got[name], want)
+		}
+	}
+}
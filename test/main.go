@@ -94,6 +94,9 @@ curl http://localhost:8001/_mycache/scores/Tom
 curl http://localhost:8001/_mycache/scores/Jack
 curl http://localhost:8001/_mycache/scores/Sam
 
+设置
+curl -X PUT --data-binary 999 http://localhost:8001/_mycache/scores/Tom
+
 删除
 curl -X DELETE http://localhost:8001/_mycache/scores/Tom
 curl -X DELETE http://localhost:8001/_mycache/scores/Sam
@@ -0,0 +1,172 @@
+package mycache
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"mycache/lru"
+	"mycache/persistence"
+)
+
+/*
+磁盘层：开启持久化后，每次写入都会追加到持久化文件，被淘汰策略移出mainCache的记录仍然保存在磁盘上。
+Conf.DiskBytes大于0时，这些只在磁盘上的key由diskTier按LRU管理，最多占用DiskBytes字节（按记录在磁盘上的大小计算）：
+cache.get在内存中未命中时先查找磁盘层，命中后将记录放回内存，不再向远程节点或getter导入；
+超出容量时，最久未访问的key会从持久化文件中删除，由后台压缩回收磁盘空间。
+持久化文件不记录过期时间，磁盘层在key被移出内存时记下它的过期时间（ByteView.expire）。
+在内存中过期而被移除的记录不会进入磁盘层，而是直接从持久化文件中删除；在磁盘层中过期的记录同样被删除，不会被放回内存。
+启动时已在持久化文件中的key没有记录过期时间，以写入时的版本（毫秒时间戳）加上Group的默认有效期作为过期时间。
+锁的顺序为：分片锁→diskTier.mu→WriteSequence的锁。
+*/
+type diskTier struct {
+	mu       sync.Mutex
+	keys     *lru.Cache                 // 只保存在磁盘上的key，值为diskEntry
+	ws       *persistence.WriteSequence // 持久化工具
+	ttl      time.Duration              // Group的默认有效期，0表示永不过期；只用于启动时已在持久化文件中的key
+	hits     *AtomicInt                 // 从磁盘层命中的次数，可以为nil
+	removing bool                       // 正在将key移回内存或主动删除，此时不删除持久化文件中的记录
+}
+
+// 磁盘层中的一个key：记录在磁盘上的大小，不包括key（lru.Cache会另外计算key的长度），以及过期时间。
+type diskEntry struct {
+	size   int64
+	expire time.Time // 零值表示永不过期
+}
+
+func (e diskEntry) Len() int64 {
+	return e.size
+}
+
+func newDiskTier(ws *persistence.WriteSequence, maxBytes int64, ttl time.Duration, hits *AtomicInt) *diskTier {
+	t := &diskTier{ws: ws, ttl: ttl, hits: hits}
+	t.keys = lru.New(maxBytes, func(key string, _ lru.ComputableValue) {
+		if t.removing {
+			return
+		}
+		if err := t.ws.Delete([]byte(key)); err != nil {
+			log.Println("[myCache] Failed to evict key from disk tier", key, err)
+		}
+	})
+	return t
+}
+
+// 以version为写入时间、按默认有效期计算的过期时间，用于没有记录过期时间的key。零值表示永不过期。
+func (t *diskTier) expireAt(version uint64) time.Time {
+	return expireFromVersion(version, t.ttl)
+}
+
+/*
+add 记录key已被移出内存、只保存在磁盘上，expire为它的过期时间，零值表示永不过期。
+已过期的记录（例如在内存中过期而被移除的记录）和放不进磁盘层的记录直接从持久化文件中删除。调用者需持有key所在分片的锁。
+*/
+func (t *diskTier) add(key string, expire time.Time) {
+	loc, ok := t.ws.IsKeyExist([]byte(key))
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expired := !expire.IsZero() && time.Now().After(expire)
+	if expired || !t.keys.AddWithExpire(key, diskEntry{int64(loc.Size) - int64(len(key)), expire}, expire) {
+		if err := t.ws.Delete([]byte(key)); err != nil {
+			log.Println("[myCache] Failed to delete key from disk tier", key, err)
+		}
+	}
+}
+
+// remove 将key移出磁盘层但保留持久化文件中的记录，用于key重新写入内存或被主动删除之前。调用者需持有key所在分片的锁。
+func (t *diskTier) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removing = true
+	t.keys.Remove(key)
+	t.removing = false
+}
+
+/*
+get 从持久化文件读取磁盘层中的key，返回带有过期时间的值，并将key移出磁盘层，由调用者放回内存。
+记录已过期时lru.Cache会将其移除，并从持久化文件中删除，视为未命中。调用者需持有key所在分片的锁。
+*/
+func (t *diskTier) get(key string) (ByteView, bool) {
+	t.mu.Lock()
+	val, ok := t.keys.Get(key)
+	if ok {
+		t.removing = true
+		t.keys.Remove(key)
+		t.removing = false
+	}
+	t.mu.Unlock()
+	if !ok {
+		return ByteView{}, false
+	}
+	entry, err := t.ws.GetEntry([]byte(key))
+	if err != nil {
+		return ByteView{}, false
+	}
+	if t.hits != nil {
+		t.hits.Add(1)
+	}
+	return ByteView{data: cloneBytes(entry.Value), version: entry.Timestamp, expire: val.(diskEntry).expire}, true
+}
+
+/*
+expireOf 返回磁盘层中key的过期时间，零值表示永不过期；key仍留在磁盘层中。
+key不在磁盘层中或已过期时ok为false，已过期的记录同时从持久化文件中删除。调用者需持有key所在分片的锁。
+*/
+func (t *diskTier) expireOf(key string) (expire time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	val, ok := t.keys.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return val.(diskEntry).expire, true
+}
+
+// 移除磁盘层中全部已过期的key，并从持久化文件中删除。
+func (t *diskTier) removeExpired() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.keys.RemoveExpired()
+}
+
+// 磁盘层中的key数量。
+func (t *diskTier) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.keys.Len()
+}
+
+/*
+enableDiskTier 为缓存器启用容量为maxBytes的磁盘层。持久化文件中不在内存里的key按版本从旧到新加入磁盘层，
+超出容量的旧记录被删除。应在NewGroup中、缓存器开始使用之前调用。
+*/
+func (c *cache) enableDiskTier(maxBytes int64, ttl time.Duration, hits *AtomicInt) {
+	t := newDiskTier(c.writeSequence, maxBytes, ttl, hits)
+	inMemory := make(map[string]bool)
+	for _, s := range c.shards {
+		for _, key := range s.data.Keys() {
+			inMemory[key] = true
+		}
+	}
+	type diskKey struct {
+		key     string
+		version uint64
+	}
+	var onDisk []diskKey
+	for _, key := range c.writeSequence.GetAllIndexKeys() {
+		if loc, ok := c.writeSequence.IsKeyExist([]byte(key)); ok && !inMemory[key] {
+			onDisk = append(onDisk, diskKey{key, loc.Timestamp})
+		}
+	}
+	sort.Slice(onDisk, func(i, j int) bool { return onDisk[i].version < onDisk[j].version })
+	for _, k := range onDisk {
+		t.add(k.key, t.expireAt(k.version))
+	}
+	c.tier = t
+	for _, s := range c.shards {
+		s.tier = t
+	}
+}
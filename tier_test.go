@@ -0,0 +1,97 @@
+package mycache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskTier(t *testing.T) {
+	loads := 0
+	value := strings.Repeat("v", 20)
+	// 内存只能放下2条记录；每条记录在磁盘上占46字节，磁盘层只能放下5条。
+	g := newTestGroup(t, Conf{Name: "disk-tier", CacheShards: 1, EnablePersistence: true, PersistencePath: t.TempDir(), DiskBytes: 5 * 46},
+		50, GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte(value), nil
+		}))
+	keys := []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9"}
+	for _, key := range keys {
+		g.Get(key)
+	}
+	if info := g.GetCacheInfo(); loads != 10 || info.KeysNum != 2 || info.DiskKeysNum != 5 {
+		t.Fatalf("loads = %d, info = %+v; want 2 keys in memory and 5 on disk", loads, info)
+	}
+	for _, key := range keys[:3] { // 超出磁盘层容量的最旧记录从持久化文件中删除
+		if _, err := g.mainCache.writeSequence.Get([]byte(key)); err == nil {
+			t.Errorf("%s still persisted after leaving the disk tier", key)
+		}
+	}
+
+	if v, err := g.Get("k5"); err != nil || v.String() != value || loads != 10 {
+		t.Fatalf("get k5 = %q, %v after %d loads; want it served from disk", v.String(), err, loads)
+	}
+	if s := g.GetStats(); s.DiskHits.Get() != 1 || s.Hits.Get() != 1 {
+		t.Fatalf("disk hits = %d, hits = %d; want 1", s.DiskHits.Get(), s.Hits.Get())
+	}
+	if _, ok := g.mainCache.get("k5"); !ok {
+		t.Fatal("k5 was not promoted back into memory")
+	}
+	g.Get("k0")
+	if loads != 11 {
+		t.Fatalf("loads = %d, want k0 reloaded after it was dropped from disk", loads)
+	}
+
+	if err := g.Delete("k3"); err != nil {
+		t.Fatal(err)
+	}
+	g.Get("k3")
+	if loads != 12 {
+		t.Fatalf("loads = %d, want deleted k3 reloaded instead of read from disk", loads)
+	}
+}
+
+// 没有默认有效期时，TTLGetter指定的过期时间同样适用于磁盘层。
+func TestDiskTierTTL(t *testing.T) {
+	loads := 0
+	value := strings.Repeat("v", 20)
+	g := newTestGroup(t, Conf{Name: "disk-tier-ttl", CacheShards: 1, EnablePersistence: true, PersistencePath: t.TempDir(), DiskBytes: 5 * 46},
+		50, TTLGetterFunc(func(key string) ([]byte, time.Duration, error) {
+			loads++
+			if strings.HasPrefix(key, "t") {
+				return []byte(value), 50 * time.Millisecond, nil
+			}
+			return []byte(value), 0, nil
+		}))
+
+	// t0在内存中过期，不会进入磁盘层，而是从持久化文件中删除。
+	g.Get("t0")
+	time.Sleep(80 * time.Millisecond)
+	if _, ok := g.mainCache.get("t0"); ok {
+		t.Fatal("expired t0 was served from the disk tier")
+	}
+	if _, err := g.mainCache.writeSequence.Get([]byte("t0")); err == nil {
+		t.Fatal("expired t0 still persisted")
+	}
+	g.Get("t0")
+	if loads != 2 {
+		t.Fatalf("loads = %d, want expired t0 reloaded", loads)
+	}
+
+	// t1被淘汰到磁盘层后过期，同样需要重新导入。
+	g.Get("t1")
+	g.Get("k0")
+	g.Get("k1")
+	if info := g.GetCacheInfo(); info.DiskKeysNum == 0 {
+		t.Fatalf("info = %+v, want t1 evicted to the disk tier", info)
+	}
+	time.Sleep(80 * time.Millisecond)
+	g.Get("t1")
+	if loads != 6 {
+		t.Fatalf("loads = %d, want t1 reloaded after it expired on disk", loads)
+	}
+	g.Get("k0") // 没有过期时间的key仍然可以从磁盘层读取
+	if loads != 6 {
+		t.Fatalf("loads = %d, want k0 served from disk", loads)
+	}
+}
@@ -0,0 +1,77 @@
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	sketchDepth   = 4  // 哈希函数（行）的数量
+	sketchMaxFreq = 15 // 计数器上限，与4bit计数器一致
+)
+
+/*
+count-min sketch：用固定大小的计数器矩阵近似统计每个key的访问频率。
+每个key在每一行对应一个计数器，估计值取各行计数器的最小值，只会高估、不会低估。
+累计记录resetAt次访问后，所有计数器减半（老化），使频率能反映近期的访问情况。
+*/
+type sketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	samples int // 自上次老化以来记录的访问次数
+	resetAt int
+}
+
+// width会被向上取整为2的幂。
+func newSketch(width int) *sketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	s := &sketch{mask: uint64(w - 1), resetAt: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// 使用双重哈希 h1+i*h2 为每一行生成下标。
+func (s *sketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *sketch) Increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < sketchMaxFreq {
+			s.rows[i][j]++
+		}
+	}
+	s.samples++
+	if s.samples >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *sketch) Estimate(key string) uint8 {
+	est := uint8(sketchMaxFreq)
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < est {
+			est = s.rows[i][j]
+		}
+	}
+	return est
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.samples /= 2
+}
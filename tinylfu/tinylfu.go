@@ -0,0 +1,264 @@
+package tinylfu
+
+import (
+	"container/list"
+	"time"
+
+	"mycache/policy"
+)
+
+const (
+	windowPercent    = 1  // 窗口LRU占总容量的百分比
+	protectedPercent = 80 // 保护区占主缓存容量的百分比
+	minSketchWidth   = 256
+	maxSketchWidth   = 1 << 20
+)
+
+/*
+W-TinyLFU缓存器，无锁。由三部分组成：
+window：容量约为1%的LRU，新记录先进入这里，吸收突发的新数据；
+probation、protected：主缓存（SLRU）。从window淘汰的记录进入probation，在probation中再次命中则升入protected；
+sketch：count-min sketch，记录所有key（包括未命中的key）的近似访问频率。
+当主缓存已满时，window淘汰出来的候选记录只有在访问频率高于probation的淘汰对象时才会被接纳（admission），
+因此一次性扫描的大量冷数据很难挤掉主缓存中的热点数据。
+*/
+type Cache struct {
+	maxBytes     int64 // 允许使用的最大内存。0表示没有限制
+	windowMax    int64 // window的容量
+	mainMax      int64 // 主缓存（probation+protected）的容量
+	protectedMax int64 // protected的容量
+	window       *list.List
+	probation    *list.List
+	protected    *list.List
+	sizes        [3]int64 // window、probation、protected各自占用的字节数，下标为where
+	nexpire      int      // 设置了过期时间的记录数量
+	sketch       *sketch
+	cache        map[string]*list.Element             // 键是字符串，值是所在链表中对应节点的指针。
+	OnEvicted    func(key string, value policy.Value) // 某条记录被移除时的回调函数，可以为 nil。
+}
+
+const (
+	inWindow = iota
+	inProbation
+	inProtected
+)
+
+type entry struct {
+	key    string
+	value  policy.Value
+	where  int       // 所在的链表
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + e.value.Len()
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(maxBytes int64, onEvicted func(string, policy.Value)) *Cache {
+	windowMax := maxBytes * windowPercent / 100
+	mainMax := maxBytes - windowMax
+	// 按平均每条记录约32字节估计sketch的宽度。
+	width := int(maxBytes / 32)
+	if width < minSketchWidth {
+		width = minSketchWidth
+	}
+	if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		mainMax:      mainMax,
+		protectedMax: mainMax * protectedPercent / 100,
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		sketch:       newSketch(width),
+		cache:        make(map[string]*list.Element),
+		OnEvicted:    onEvicted,
+	}
+}
+
+func (c *Cache) lists(where int) *list.List {
+	return [3]*list.List{c.window, c.probation, c.protected}[where]
+}
+
+func (c *Cache) Get(key string) (policy.Value, bool) {
+	c.sketch.Increment(key)
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.access(ele)
+	return e.value, true
+}
+
+// 命中后的处理：window和protected中移到front；probation中升入protected。
+func (c *Cache) access(ele *list.Element) {
+	e := ele.Value.(*entry)
+	if e.where != inProbation {
+		c.lists(e.where).MoveToFront(ele)
+		return
+	}
+	c.move(ele, inProtected)
+	for c.sizes[inProtected] > c.protectedMax && c.protected.Len() > 1 {
+		// protected超出容量，将其中最久未访问的记录降回probation。
+		c.move(c.protected.Back(), inProbation)
+	}
+}
+
+func (c *Cache) Add(key string, val policy.Value) bool {
+	return c.AddWithExpire(key, val, time.Time{})
+}
+
+func (c *Cache) AddWithExpire(key string, val policy.Value, expire time.Time) bool {
+	if c.maxBytes > 0 && int64(len(key))+val.Len() > c.maxBytes {
+		return false
+	}
+	c.sketch.Increment(key)
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		c.sizes[e.where] += val.Len() - e.value.Len()
+		e.value = val
+		c.setExpire(e, expire)
+		c.access(ele)
+	} else {
+		e := &entry{key: key, value: val, where: inWindow}
+		c.setExpire(e, expire)
+		c.cache[key] = c.window.PushFront(e)
+		c.sizes[inWindow] += e.size()
+	}
+	c.evict()
+	_, ok := c.cache[key] // 主缓存可能拒绝接纳key
+	return ok
+}
+
+// window超出容量时，将其中最久未访问的记录作为候选者交给主缓存决定是否接纳。
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.sizes[inWindow] > c.windowMax && c.window.Len() > 0 {
+		c.admit(c.window.Back())
+	}
+	// 更新已有记录可能使主缓存超出容量。
+	for c.sizes[inProbation]+c.sizes[inProtected] > c.mainMax {
+		victim := c.probation.Back()
+		if victim == nil {
+			victim = c.protected.Back()
+		}
+		c.removeElement(victim)
+	}
+}
+
+// 主缓存空间不足时，比较候选者与probation淘汰对象的访问频率，淘汰频率较低的一方。
+func (c *Cache) admit(cand *list.Element) {
+	ce := cand.Value.(*entry)
+	c.move(cand, inProbation)
+	c.probation.MoveToBack(cand) // 暂时放到队尾，其前一个节点就是probation中最久未访问的记录
+	for c.sizes[inProbation]+c.sizes[inProtected] > c.mainMax {
+		victim := cand.Prev()
+		if victim == nil {
+			victim = c.protected.Back()
+		}
+		if victim == nil {
+			c.removeElement(cand)
+			return
+		}
+		if c.sketch.Estimate(ce.key) <= c.sketch.Estimate(victim.Value.(*entry).key) {
+			c.removeElement(cand)
+			return
+		}
+		c.removeElement(victim)
+	}
+	c.probation.MoveToFront(cand)
+}
+
+// 将记录移动到where链表的front。
+func (c *Cache) move(ele *list.Element, where int) {
+	e := ele.Value.(*entry)
+	c.lists(e.where).Remove(ele)
+	c.sizes[e.where] -= e.size()
+	e.where = where
+	c.cache[e.key] = c.lists(where).PushFront(e)
+	c.sizes[where] += e.size()
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	if c.nexpire == 0 {
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for _, ele := range c.cache {
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Keys 返回全部未过期记录的key，不更新访问频率。
+func (c *Cache) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, len(c.cache))
+	for key, ele := range c.cache {
+		if !ele.Value.(*entry).expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *Cache) GetCurrentUsedBytes() int64 {
+	return c.sizes[inWindow] + c.sizes[inProbation] + c.sizes[inProtected]
+}
+
+func (c *Cache) GetMaxUsedBytes() int64 {
+	return c.maxBytes
+}
+
+func (c *Cache) setExpire(e *entry, expire time.Time) {
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if !expire.IsZero() {
+		c.nexpire++
+	}
+	e.expire = expire
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.lists(e.where).Remove(ele)
+	c.sizes[e.where] -= e.size()
+	delete(c.cache, e.key)
+	if !e.expire.IsZero() {
+		c.nexpire--
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+var _ policy.Policy = (*Cache)(nil)
@@ -0,0 +1,67 @@
+package tinylfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+type String string
+
+func (d String) Len() int64 {
+	return int64(len(d))
+}
+
+func TestGet(t *testing.T) {
+	tlfu := New(int64(0), nil)
+	tlfu.Add("key1", String("1234"))
+	if v, ok := tlfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := tlfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	// 每条记录占 4+2=6 字节，容量可以容纳100条。
+	tlfu := New(int64(600), nil)
+	var hot []string
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("h%03d", i)
+		hot = append(hot, k)
+		tlfu.Add(k, String("vv"))
+	}
+	for round := 0; round < 5; round++ {
+		for _, k := range hot {
+			tlfu.Get(k)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		tlfu.Add(fmt.Sprintf("%04d", i), String("vv"))
+	}
+	for _, k := range hot {
+		if _, ok := tlfu.Get(k); !ok {
+			t.Fatalf("hot key %s should survive a scan", k)
+		}
+	}
+	if tlfu.GetCurrentUsedBytes() > tlfu.GetMaxUsedBytes() {
+		t.Fatalf("used %d bytes, more than max %d", tlfu.GetCurrentUsedBytes(), tlfu.GetMaxUsedBytes())
+	}
+}
+
+func TestAddReportsRejection(t *testing.T) {
+	// 每条记录占 4+4=8 字节，大于window的容量，新记录直接作为候选者交给主缓存。
+	tlfu := New(int64(600), nil)
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("h%03d", i)
+		tlfu.Add(k, String("vvvv"))
+		tlfu.Get(k)
+		tlfu.Get(k)
+	}
+	if ok := tlfu.Add("cold", String("vvvv")); ok {
+		t.Fatal("add of a cold key rejected by admission returned true")
+	}
+	if _, ok := tlfu.Get("cold"); ok {
+		t.Fatal("rejected key is cached")
+	}
+}